@@ -10,6 +10,10 @@ export function getStateName(state: RaftState): string {
       return "Leader"
     case RaftState.DEAD:
       return "Dead"
+    case RaftState.LEARNER:
+      return "Learner"
+    case RaftState.FAULTED:
+      return "Faulted"
     case RaftState.DISCONNECTED:
       return "Disconnected"
     default:
@@ -27,6 +31,10 @@ export function getStateColor(state: RaftState): string {
       return "text-emerald-400"
     case RaftState.DEAD:
       return "text-zinc-400"
+    case RaftState.LEARNER:
+      return "text-violet-400"
+    case RaftState.FAULTED:
+      return "text-orange-500"
     case RaftState.DISCONNECTED:
       return "text-pink-500"
     default:
@@ -44,6 +52,10 @@ export function getStateBgColor(state: RaftState): string {
       return "bg-green-500"
     case RaftState.DEAD:
       return "bg-gray-500"
+    case RaftState.LEARNER:
+      return "bg-violet-400"
+    case RaftState.FAULTED:
+      return "bg-orange-500"
     case RaftState.DISCONNECTED:
       return "bg-pink-500/90"
     default:
@@ -3,6 +3,8 @@ export enum RaftState {
     CANDIDATE = 'Candidate',
     LEADER = 'Leader',
     DEAD = 'Dead',
+    LEARNER = 'Learner',
+    FAULTED = 'Faulted',
     DISCONNECTED = 'Disconnected',
 
 }
@@ -46,6 +46,7 @@ export interface StateTransitionLog extends ServerLog {
   message: LogMessageType.STATE_TRANSITION;
   oldState: RaftState;
   newState: RaftState;
+  reason?: string;
 }
 
 export interface VoteLog extends ServerLog {
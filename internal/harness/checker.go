@@ -0,0 +1,242 @@
+package harness
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pro0o/raft-in-motion/internal/raft"
+
+	"github.com/rs/zerolog"
+)
+
+// SAFETY CHECKER
+// Whatever faults hit it, Raft guarantees (figure 3 of the Raft paper):
+//   - Election Safety: at most one leader is elected in a term.
+//   - Log Matching: logs holding an entry with the same index and term hold
+//     the same entries up to it.
+//   - Leader Completeness: an entry committed in a term is in the log of
+//     every leader of a later term.
+//   - State Machine Safety: no two nodes apply different entries at the
+//     same index.
+// Every harness watches its nodes with a safetyChecker, told of elections
+// and applied entries as raft.Observer and comparing the nodes' logs twice
+// per heartbeat, and reports the first guarantee that breaks along with the
+// events leading up to it.
+
+// safetyHistory is how many of the latest events a violation comes with.
+const safetyHistory = 48
+
+// SafetyViolation is a Raft safety guarantee the cluster broke.
+type SafetyViolation struct {
+	Invariant string
+	Detail    string
+	History   []string // the events the checker saw last, oldest first
+}
+
+func (v *SafetyViolation) Error() string {
+	return fmt.Sprintf("%s violated: %s", v.Invariant, v.Detail)
+}
+
+// appliedEntry is the entry first applied at an index.
+type appliedEntry struct {
+	node    int
+	term    int
+	command any
+}
+
+type safetyChecker struct {
+	mu        sync.Mutex
+	leaders   map[int]int          // the leader elected in each term
+	applied   map[int]appliedEntry // by index; every one of them committed
+	history   []string
+	violation *SafetyViolation
+	expected  bool // the scenario breaks Raft's assumptions on purpose
+	logger    *zerolog.Logger
+}
+
+func newSafetyChecker(logger *zerolog.Logger) *safetyChecker {
+	return &safetyChecker{
+		leaders: make(map[int]int),
+		applied: make(map[int]appliedEntry),
+		logger:  logger,
+	}
+}
+
+// record adds an event to the history. Expects c.mu to be locked.
+func (c *safetyChecker) record(format string, args ...any) {
+	if len(c.history) == safetyHistory {
+		c.history = c.history[1:]
+	}
+	c.history = append(c.history, fmt.Sprintf(format, args...))
+}
+
+// violated reports a broken guarantee, unless one was reported already.
+// Expects c.mu to be locked.
+func (c *safetyChecker) violated(invariant string, format string, args ...any) {
+	if c.violation != nil {
+		return
+	}
+	c.violation = &SafetyViolation{
+		Invariant: invariant,
+		Detail:    fmt.Sprintf(format, args...),
+		History:   append([]string(nil), c.history...),
+	}
+	ev := c.logger.Error()
+	msg := "Test failed: Raft safety violated"
+	if c.expected {
+		ev = c.logger.Info()
+		msg = "safetyViolated"
+	}
+	ev.Str("invariant", invariant).
+		Str("detail", c.violation.Detail).
+		Strs("history", c.violation.History).
+		Msg(msg)
+}
+
+func (c *safetyChecker) LeaderElected(id int, term int, view raft.LogView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("node %d elected leader of term %d, log up to %d", id, term, view.SnapshotIndex+len(view.Entries))
+
+	if other, ok := c.leaders[term]; ok && other != id {
+		c.violated("Election Safety", "nodes %d and %d were both elected leader of term %d", other, id, term)
+	}
+	c.leaders[term] = id
+
+	// every entry applied so far was committed before this election.
+	missing := -1
+	for index, e := range c.applied {
+		if index <= view.SnapshotIndex || (missing >= 0 && index > missing) {
+			continue
+		}
+		pos := index - view.SnapshotIndex - 1
+		if pos >= len(view.Entries) || view.Entries[pos].Term != e.term {
+			missing = index
+		}
+	}
+	if missing >= 0 {
+		e := c.applied[missing]
+		c.violated("Leader Completeness", "node %d leads term %d without entry %d of term %d, which node %d applied",
+			id, term, missing, e.term, e.node)
+	}
+}
+
+func (c *safetyChecker) Applied(id int, entry raft.CommitEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.Snapshot != nil {
+		c.record("node %d applied a snapshot up to %d of term %d", id, entry.Index, entry.Term)
+		if e, ok := c.applied[entry.Index]; ok && e.term != entry.Term {
+			c.violated("State Machine Safety", "node %d applied a snapshot up to entry %d of term %d, node %d applied it from term %d",
+				id, entry.Index, entry.Term, e.node, e.term)
+		}
+		return
+	}
+	c.record("node %d applied %d of term %d: %+v", id, entry.Index, entry.Term, entry.Command)
+
+	e, ok := c.applied[entry.Index]
+	if !ok {
+		c.applied[entry.Index] = appliedEntry{node: id, term: entry.Term, command: entry.Command}
+		return
+	}
+	if e.term != entry.Term || !reflect.DeepEqual(e.command, entry.Command) {
+		c.violated("State Machine Safety", "node %d applied %+v of term %d at %d, node %d applied %+v of term %d",
+			id, entry.Command, entry.Term, entry.Index, e.node, e.command, e.term)
+	}
+}
+
+// checkLogs compares the logs of every pair of nodes: up to the last entry
+// two logs share, they must hold the same entries.
+func (c *safetyChecker) checkLogs(views []raft.LogView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for a := range views {
+		for b := a + 1; b < len(views); b++ {
+			if index, shared, ok := logsMatch(views[a], views[b]); !ok {
+				c.violated("Log Matching", "nodes %d and %d both hold entry %d of term %d but differ at %d",
+					a, b, shared, entryAt(views[a], shared).Term, index)
+				return
+			}
+		}
+	}
+}
+
+// logsMatch reports whether a and b hold the same entries up to the last
+// one they share, past both snapshots. If not, it also returns the first
+// index they differ at and the shared entry.
+func logsMatch(a, b raft.LogView) (index int, shared int, ok bool) {
+	from := max(a.SnapshotIndex, b.SnapshotIndex) + 1
+	to := min(a.SnapshotIndex+len(a.Entries), b.SnapshotIndex+len(b.Entries))
+	shared = -1
+	for i := to; i >= from; i-- {
+		if entryAt(a, i).Term == entryAt(b, i).Term {
+			shared = i
+			break
+		}
+	}
+	for i := from; i <= shared; i++ {
+		ea, eb := entryAt(a, i), entryAt(b, i)
+		if ea.Term != eb.Term || !reflect.DeepEqual(ea.Command, eb.Command) {
+			return i, shared, false
+		}
+	}
+	return -1, shared, true
+}
+
+func entryAt(v raft.LogView, index int) raft.LogEntry {
+	return v.Entries[index-v.SnapshotIndex-1]
+}
+
+// watchSafety compares the nodes' logs twice per heartbeat until Shutdown.
+func (h *Harness) watchSafety() {
+	defer close(h.safetyDone)
+	for {
+		select {
+		case <-h.stopSafety:
+			return
+		case <-time.After(h.scaled(25 * time.Millisecond)):
+		}
+		h.checkLogs()
+	}
+}
+
+func (h *Harness) checkLogs() {
+	h.tickMu.Lock()
+	nodes := slices.Clone(h.kvCluster)
+	h.tickMu.Unlock()
+	views := make([]raft.LogView, len(nodes))
+	for i, kvs := range nodes {
+		views[i] = kvs.Inspect()
+	}
+	h.safety.checkLogs(views)
+}
+
+// SafetyViolation returns the first Raft safety guarantee the cluster broke
+// so far, or nil.
+func (h *Harness) SafetyViolation() error {
+	h.safety.mu.Lock()
+	defer h.safety.mu.Unlock()
+	if h.safety.violation == nil {
+		return nil
+	}
+	return h.safety.violation
+}
+
+func (h *Harness) safetyExpected() bool {
+	h.safety.mu.Lock()
+	defer h.safety.mu.Unlock()
+	return h.safety.expected
+}
+
+// ExpectSafetyViolation tells the checker the scenario breaks Raft's
+// assumptions on purpose, so a broken guarantee is what it sets out to
+// show rather than a failure.
+func (h *Harness) ExpectSafetyViolation() {
+	h.safety.mu.Lock()
+	defer h.safety.mu.Unlock()
+	h.safety.expected = true
+	h.logger.Info().Msg("safetyViolationExpected")
+}
@@ -0,0 +1,67 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/pro0o/raft-in-motion/internal/raft"
+
+	"github.com/rs/zerolog/log"
+)
+
+func TestSafetyCheckerCatchesViolations(t *testing.T) {
+	entries := func(terms ...int) []raft.LogEntry {
+		var log []raft.LogEntry
+		for _, term := range terms {
+			log = append(log, raft.LogEntry{Term: term, Command: term})
+		}
+		return log
+	}
+	view := func(terms ...int) raft.LogView {
+		return raft.LogView{SnapshotIndex: -1, Entries: entries(terms...)}
+	}
+
+	tests := []struct {
+		name      string
+		invariant string // "" when nothing breaks
+		run       func(c *safetyChecker)
+	}{
+		{"healthy", "", func(c *safetyChecker) {
+			c.LeaderElected(0, 1, view(1))
+			c.Applied(0, raft.CommitEntry{Index: 0, Term: 1, Command: 1})
+			c.Applied(1, raft.CommitEntry{Index: 0, Term: 1, Command: 1})
+			c.LeaderElected(1, 2, view(1, 2))
+			c.checkLogs([]raft.LogView{view(1, 2, 2), view(1, 2), view(1, 1)})
+		}},
+		{"two leaders in a term", "Election Safety", func(c *safetyChecker) {
+			c.LeaderElected(0, 1, view(1))
+			c.LeaderElected(1, 1, view(1))
+		}},
+		{"logs differ before a shared entry", "Log Matching", func(c *safetyChecker) {
+			c.checkLogs([]raft.LogView{view(1, 1, 2), view(1, 2, 2)})
+		}},
+		{"leader without a committed entry", "Leader Completeness", func(c *safetyChecker) {
+			c.Applied(0, raft.CommitEntry{Index: 0, Term: 1, Command: 1})
+			c.Applied(0, raft.CommitEntry{Index: 1, Term: 1, Command: 1})
+			c.LeaderElected(1, 2, view(1, 2))
+		}},
+		{"different entries applied at an index", "State Machine Safety", func(c *safetyChecker) {
+			c.Applied(0, raft.CommitEntry{Index: 0, Term: 1, Command: 1})
+			c.Applied(1, raft.CommitEntry{Index: 0, Term: 2, Command: 2})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSafetyChecker(&log.Logger)
+			c.expected = true // keep the log quiet
+			tt.run(c)
+			switch {
+			case tt.invariant == "" && c.violation != nil:
+				t.Fatalf("unexpected violation: %v", c.violation)
+			case tt.invariant != "" && c.violation == nil:
+				t.Fatalf("%s violation missed", tt.invariant)
+			case tt.invariant != "" && c.violation.Invariant != tt.invariant:
+				t.Fatalf("got %v, want a %s violation", c.violation, tt.invariant)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/pro0o/raft-in-motion/internal/raft"
+)
+
+// CRASH POINTS
+// A node writes its state as a sequence of batches. Replaying the batches a
+// cluster committed into a WAL and cutting the WAL off at every one of them,
+// and in the middle of every one, gives each point the node could have
+// crashed at. Recovery at each of them must find the state after exactly the
+// batches written in full: never part of one.
+
+// recordingStorage is a MapStorage that keeps every batch committed to it.
+type recordingStorage struct {
+	*raft.MapStorage
+	mu      sync.Mutex
+	batches []*raft.Batch
+}
+
+func (s *recordingStorage) Commit(b *raft.Batch) error {
+	if err := s.MapStorage.Commit(b); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+func (s *recordingStorage) recorded() []*raft.Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.batches)
+}
+
+// recordingStorages keeps each node in memory, like MemoryStorage, and records
+// the batches it commits in the returned map, by node id.
+func recordingStorages() (StorageFactory, map[int]*recordingStorage) {
+	var mu sync.Mutex
+	storage := make(map[int]*recordingStorage)
+	return func(id int) (raft.Storage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		s, ok := storage[id]
+		if !ok {
+			s = &recordingStorage{MapStorage: raft.NewMapStorage()}
+			storage[id] = s
+		}
+		return s, nil
+	}, storage
+}
+
+// checkCrashPoints replays batches into a WAL under dir and, before each
+// batch and at a few cuts inside its record, recovers a copy of the WAL and
+// compares it with the state the batches before it leave. It returns the
+// number of crash points checked and the mismatches found.
+func checkCrashPoints(dir string, batches []*raft.Batch) (int, []error, error) {
+	opts := raft.DefaultWALOptions()
+	// one segment for the whole run, so a batch's record is always the tail
+	// of the newest segment. Rotation is covered by recovery falling back to
+	// the last segment with a complete checkpoint.
+	opts.SegmentSize = 1 << 40
+	opts.Sync = raft.SyncNever
+
+	live := filepath.Join(dir, "live")
+	wal, err := raft.OpenWALStorage(live, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer wal.Close()
+
+	want := raft.NewMapStorage()
+	keys := make(map[string]bool)
+	points := 0
+	var mismatches []error
+
+	for k, b := range batches {
+		before, err := newestSegmentSize(live)
+		if err != nil {
+			return points, mismatches, err
+		}
+		if err := wal.Commit(b); err != nil {
+			return points, mismatches, fmt.Errorf("batch %d: %w", k, err)
+		}
+		after, err := newestSegmentSize(live)
+		if err != nil {
+			return points, mismatches, err
+		}
+
+		// crash before the write, and with 1, half and all but one of the
+		// record's bytes written.
+		record := after - before
+		cuts := []int64{0}
+		for _, kept := range []int64{1, record / 2, record - 1} {
+			if kept > 0 && kept < record && !slices.Contains(cuts, kept) {
+				cuts = append(cuts, kept)
+			}
+		}
+		for _, kept := range cuts {
+			points++
+			crashed := filepath.Join(dir, fmt.Sprintf("crash-%d-%d", k, kept))
+			if err := crashCopy(live, crashed, before+kept); err != nil {
+				return points, mismatches, err
+			}
+			if err := compareRecovered(crashed, opts, want, keys); err != nil {
+				mismatches = append(mismatches, fmt.Errorf("batch %d, %d of %d bytes written: %w", k, kept, record, err))
+			}
+			os.RemoveAll(crashed)
+		}
+
+		if err := want.Commit(b); err != nil {
+			return points, mismatches, fmt.Errorf("batch %d: %w", k, err)
+		}
+		for _, op := range b.Ops() {
+			if op.Kind == raft.BatchSet {
+				keys[op.Key] = true
+			}
+		}
+	}
+
+	// and after the last write.
+	points++
+	crashed := filepath.Join(dir, "crash-end")
+	size, err := newestSegmentSize(live)
+	if err != nil {
+		return points, mismatches, err
+	}
+	if err := crashCopy(live, crashed, size); err != nil {
+		return points, mismatches, err
+	}
+	if err := compareRecovered(crashed, opts, want, keys); err != nil {
+		mismatches = append(mismatches, fmt.Errorf("after batch %d: %w", len(batches)-1, err))
+	}
+	return points, mismatches, nil
+}
+
+// crashCopy copies the segments in src to dst, keeping only the first size
+// bytes of the newest one.
+func crashCopy(src, dst string, size int64) error {
+	names, err := segmentNames(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for i, name := range names {
+		in, err := os.Open(filepath.Join(src, name))
+		if err != nil {
+			return err
+		}
+		out, err := os.Create(filepath.Join(dst, name))
+		if err != nil {
+			in.Close()
+			return err
+		}
+		var r io.Reader = in
+		if i == len(names)-1 {
+			r = io.LimitReader(in, size)
+		}
+		_, err = io.Copy(out, r)
+		in.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareRecovered opens the WAL in dir and checks it holds what want holds.
+func compareRecovered(dir string, opts raft.WALOptions, want *raft.MapStorage, keys map[string]bool) error {
+	got, err := raft.OpenWALStorage(dir, opts)
+	if err != nil {
+		return fmt.Errorf("recovering: %w", err)
+	}
+	defer got.Close()
+
+	for key := range keys {
+		gv, gfound, err := got.Get(key)
+		if err != nil {
+			return err
+		}
+		wv, wfound, _ := want.Get(key)
+		if gfound != wfound || !bytes.Equal(gv, wv) {
+			return fmt.Errorf("key %q: recovered %d bytes (found %v), want %d bytes (found %v)", key, len(gv), gfound, len(wv), wfound)
+		}
+	}
+
+	gfirst, gentries, err := got.Entries()
+	if err != nil {
+		return err
+	}
+	wfirst, wentries, _ := want.Entries()
+	if len(gentries) == 0 && len(wentries) == 0 {
+		return nil
+	}
+	if gfirst != wfirst || len(gentries) != len(wentries) {
+		return fmt.Errorf("recovered entries [%d, %d), want [%d, %d)", gfirst, gfirst+len(gentries), wfirst, wfirst+len(wentries))
+	}
+	for i := range gentries {
+		if !bytes.Equal(gentries[i], wentries[i]) {
+			return fmt.Errorf("entry %d differs", gfirst+i)
+		}
+	}
+	return nil
+}
+
+func segmentNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".wal" {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func newestSegmentSize(dir string) (int64, error) {
+	names, err := segmentNames(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	info, err := os.Stat(filepath.Join(dir, names[len(names)-1]))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// logCrashPoints checks the crash points of every recorded node.
+func (h *Harness) logCrashPoints(dir string, storage map[int]*recordingStorage) {
+	for id, s := range storage {
+		batches := s.recorded()
+		points, mismatches, err := checkCrashPoints(filepath.Join(dir, fmt.Sprintf("node-%d", id)), batches)
+		if err != nil {
+			h.logger.Error().Int("raftID", id).Err(err).Msg("crashPointsFailed")
+			continue
+		}
+		for _, mismatch := range mismatches {
+			h.logger.Error().Int("raftID", id).Err(mismatch).Msg("crashPointMismatch")
+		}
+		h.logger.Info().
+			Int("raftID", id).
+			Int("batches", len(batches)).
+			Int("crashPoints", points).
+			Int("mismatches", len(mismatches)).
+			Msg("crashPointsChecked")
+	}
+}
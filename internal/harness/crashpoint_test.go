@@ -0,0 +1,131 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pro0o/raft-in-motion/internal/raft"
+)
+
+func TestCrashPoints(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a cluster")
+	}
+	c := initClient()
+	newStorage, storage := recordingStorages()
+	h, err := NewHarnessWithStorage(3, c, raft.DefaultConfig(), newStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		h.Shutdown()
+		t.Fatal(err)
+	}
+	if err := h.checkPuts(h.NewClient(c), 0, 5); err != nil {
+		t.Error(err)
+	}
+	h.CrashService(lid)
+	if _, err := h.CheckSingleLeader(); err != nil {
+		t.Error(err)
+	}
+	if err := h.checkPuts(h.NewClient(c), 5, 10); err != nil {
+		t.Error(err)
+	}
+	h.RestartService(lid)
+	h.sleepMs(300)
+	if err := h.Shutdown(); err != nil {
+		t.Error(err)
+	}
+
+	for id, s := range storage {
+		batches := s.recorded()
+		if len(batches) == 0 {
+			t.Errorf("node %d recorded no batches", id)
+			continue
+		}
+		points, mismatches, err := checkCrashPoints(filepath.Join(t.TempDir(), fmt.Sprint(id)), batches)
+		if err != nil {
+			t.Fatalf("node %d: %v", id, err)
+		}
+		for _, mismatch := range mismatches {
+			t.Errorf("node %d: %v", id, mismatch)
+		}
+		if points <= len(batches) {
+			t.Errorf("node %d: %d crash points for %d batches, want cuts inside them too", id, points, len(batches))
+		}
+	}
+}
+
+func TestCrashInsideBatch(t *testing.T) {
+	entries := func(values ...string) [][]byte {
+		var entries [][]byte
+		for _, v := range values {
+			entries = append(entries, []byte(v))
+		}
+		return entries
+	}
+	var first, second raft.Batch
+	first.Set("term", []byte("1"))
+	first.AppendEntries(0, entries("a", "b", "c"))
+	// a new term, a truncated suffix and a snapshot, all or nothing.
+	second.Set("term", []byte("2"))
+	second.AppendEntries(1, entries("x", "y"))
+	second.Set("snapshot", []byte("s1"))
+	second.CompactEntries(1)
+
+	dir := t.TempDir()
+	points, mismatches, err := checkCrashPoints(filepath.Join(dir, "points"), []*raft.Batch{&first, &second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mismatch := range mismatches {
+		t.Error(mismatch)
+	}
+	if points != 9 {
+		t.Errorf("checked %d crash points, want 9", points)
+	}
+
+	// cut the second record in half by hand: recovery finds the first batch
+	// only.
+	opts := raft.DefaultWALOptions()
+	live := filepath.Join(dir, "live")
+	w, err := raft.OpenWALStorage(live, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*raft.Batch{&first, &second} {
+		if err := w.Commit(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := segmentNames(live)
+	if err != nil || len(names) != 1 {
+		t.Fatalf("segments %v: %v", names, err)
+	}
+	size, err := newestSegmentSize(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstOnly := raft.NewMapStorage()
+	if err := firstOnly.Commit(&first); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]bool{"term": true, "snapshot": true}
+	crashed := filepath.Join(dir, "crashed")
+	for _, cut := range []int64{size - 1, size - 8} {
+		os.RemoveAll(crashed)
+		if err := crashCopy(live, crashed, cut); err != nil {
+			t.Fatal(err)
+		}
+		if err := compareRecovered(crashed, opts, firstOnly, keys); err != nil {
+			t.Errorf("cut at %d of %d bytes: %v", cut, size, err)
+		}
+	}
+}
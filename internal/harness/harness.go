@@ -2,19 +2,26 @@ package harness
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"math/rand/v2"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	clit "github.com/pro0o/raft-in-motion/internal/client"
 	"github.com/pro0o/raft-in-motion/internal/kv/client"
 	"github.com/pro0o/raft-in-motion/internal/kv/server"
+	"github.com/pro0o/raft-in-motion/internal/kv/types"
 	"github.com/pro0o/raft-in-motion/internal/logger"
 	"github.com/pro0o/raft-in-motion/internal/raft"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/zap"
 )
@@ -30,7 +37,7 @@ func NewPortManager(initialPort int) *PortManager {
 }
 
 func (pm *PortManager) NextPortRange(count int) []int {
-	start := atomic.AddInt32(&pm.basePort, int32(count))
+	start := atomic.AddInt32(&pm.basePort, int32(count)) - int32(count)
 	ports := make([]int, count)
 	for i := 0; i < count; i++ {
 		ports[i] = int(start) + i
@@ -42,24 +49,231 @@ type Harness struct {
 	n              int
 	kvCluster      []*server.KVService
 	kvServiceAddrs []string
-	storage        []*raft.MapStorage
+	storage        []raft.Storage
+	newStorage     StorageFactory
+	diskFaults     map[int]raft.DiskFaults // survive restarts, by node id
 	connected      []bool
 	alive          []bool
 	ctx            context.Context
 	ctxCancel      func()
 	c              *clit.Client
+	preVote        bool
+	leaseRead      bool
+	maxClockDrift  float64
+	cfg            raft.Config
+	timeScale      float64 // how much slower than DefaultConfig the cluster runs
+
+	// each node's own clock, skewed by SetClockSkew; nodes whose clock
+	// cfg.Overrides sets have none.
+	clocks map[int]*raft.SkewedClock
+
+	// what the cluster and the harness log events to, cfg.Logger.
+	logger *zerolog.Logger
+
+	// Set for TickDriven clusters: the harness ticks every node itself,
+	// advancing clock (when virtual) by one TickInterval per round.
+	clock     *raft.VirtualClock
+	tickMu    sync.Mutex // guards kvCluster against the tick loop and the safety checker
+	stopTicks chan struct{}
+
+	// Set when the harness drives virtual time itself: instead of a tick
+	// loop, every wait of the harness runs rounds, each advancing clock,
+	// ticking every node and delivering what network has due, all from the
+	// waiting goroutine. The same seed then replays the same events.
+	network *raft.ChannelNetwork
+	// client requests under way; they are served in real time, so while
+	// any is, rounds take virtualTickPause of it to let them keep up.
+	inFlight atomic.Int32
+
+	// watches every node for broken Raft safety guarantees until Shutdown.
+	safety     *safetyChecker
+	stopSafety chan struct{}
+	safetyDone chan struct{}
+
+	// the steps the last RunNemesis took, in order.
+	nemesisTrace []NemesisStep
+}
+
+// StorageFactory opens the storage for node id. A restarted node gets its
+// storage from the factory again, so a factory backed by files recovers what
+// the node wrote before it crashed.
+type StorageFactory func(id int) (raft.Storage, error)
+
+// MemoryStorage keeps every node's state in a MapStorage. A restarted node
+// reuses the MapStorage it had before.
+func MemoryStorage() StorageFactory {
+	storage := make(map[int]raft.Storage)
+	var mu sync.Mutex
+	return func(id int) (raft.Storage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := storage[id]; !ok {
+			storage[id] = raft.NewMapStorage()
+		}
+		return storage[id], nil
+	}
+}
+
+// WALStorage keeps node id's state in a write-ahead log under dir/node-<id>.
+func WALStorage(dir string, opts raft.WALOptions) StorageFactory {
+	return func(id int) (raft.Storage, error) {
+		nodeDir := filepath.Join(dir, fmt.Sprintf("node-%d", id))
+		w, err := raft.OpenWALStorage(nodeDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		if torn := w.TornTail(); torn > 0 {
+			logger.Info("Dropped torn WAL tail", zap.Int("serverID", id), zap.String("dir", nodeDir), zap.Int64("bytes", torn))
+		}
+		return w, nil
+	}
+}
+
+// closeStorage closes node id's storage if it holds files.
+func (h *Harness) closeStorage(id int) {
+	if closer, ok := h.storage[id].(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("Error closing storage", zap.Int("serverID", id), zap.Error(err))
+		}
+	}
+}
+
+// openStorage opens node id's storage through the harness' factory, with
+// the disk faults set for it.
+func (h *Harness) openStorage(id int) raft.Storage {
+	storage := openStorage(h.newStorage, id)
+	if fs, ok := storage.(*raft.FaultyStorage); ok {
+		fs.SetFaults(h.diskFaults[id])
+	}
+	return storage
+}
+
+// openStorage opens node id's storage with newStorage, wrapped in a
+// FaultyStorage that stays healthy until SetDiskFaults. Storage that fails to
+// open comes up Faulted instead of taking the whole simulation down.
+func openStorage(newStorage StorageFactory, id int) raft.Storage {
+	storage, err := newStorage(id)
+	if err != nil {
+		logger.Error("Error opening storage", zap.Int("serverID", id), zap.Error(err))
+		return brokenStorage{err: err}
+	}
+	return raft.NewFaultyStorage(storage)
+}
+
+// brokenStorage fails every call with err. Raft finds data in it and then
+// fails to restore, which faults the node right away.
+type brokenStorage struct {
+	err error
+}
+
+func (s brokenStorage) Commit(*raft.Batch) error         { return s.err }
+func (s brokenStorage) Get(string) ([]byte, bool, error) { return nil, false, s.err }
+func (s brokenStorage) Entries() (int, [][]byte, error)  { return 0, nil, s.err }
+func (s brokenStorage) HasData() bool                    { return true }
+
+// virtualTickPause is the real time a round of virtual time takes when
+// something outside the rounds needs to keep up: RPCs over a transport the
+// harness doesn't deliver itself, or client requests served over HTTP.
+const virtualTickPause = time.Millisecond
+
+// skewableClock gives node id a SkewedClock over cfg.Clock through
+// cfg.Overrides and returns it, or returns nil if an override already picks
+// the node's clock.
+func skewableClock(cfg *raft.Config, id int) *raft.SkewedClock {
+	o := cfg.Overrides[id]
+	if o.Clock != nil {
+		return nil
+	}
+	clock := raft.NewSkewedClock(cfg.Clock)
+	o.Clock = clock
+	cfg.Overrides[id] = o
+	return clock
+}
+
+// eventLogger returns the logger a cluster running on clock logs to. Under
+// virtual time events are stamped with the virtual clock, so runs with one
+// seed log the same bytes; otherwise they go to the global log.Logger.
+func eventLogger(clock *raft.VirtualClock) *zerolog.Logger {
+	if clock == nil {
+		return &log.Logger
+	}
+	l := zerolog.New(logger.Output).Hook(clockTimestamp{clock})
+	return &l
+}
+
+// clockTimestamp stamps every event with the time on clock.
+type clockTimestamp struct {
+	clock raft.Clock
+}
+
+func (t clockTimestamp) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Time(zerolog.TimestampFieldName, t.clock.Now())
+}
+
+// VirtualTime returns cfg set up to run under virtual time: nodes are driven
+// by the harness' ticks, share one virtual clock and draw their randomness
+// from seed, so a scenario runs faster than real time and repeats per seed.
+func VirtualTime(cfg raft.Config, seed int64) raft.Config {
+	cfg.Clock = raft.NewVirtualClock(time.Unix(0, 0).UTC())
+	cfg.Seed = seed
+	cfg.TickDriven = true
+	return cfg
 }
 
 var portManager = NewPortManager(14200)
 
-func NewHarness(n int, c *clit.Client) *Harness {
+// NewHarness starts an n-node cluster running with cfg. The harness' own
+// waits and client timeouts stretch along with the cluster's timings. Nodes
+// talk over an in-process channel network unless cfg.NewTransport says
+// otherwise, so clusters of up to a hundred nodes fit in one process. It
+// fails if cfg is not a valid Raft configuration.
+func NewHarness(n int, c *clit.Client, cfg raft.Config) (*Harness, error) {
+	return NewHarnessWithStorage(n, c, cfg, MemoryStorage())
+}
+
+// NewHarnessWithStorage is NewHarness with every node's storage opened by
+// newStorage.
+func NewHarnessWithStorage(n int, c *clit.Client, cfg raft.Config, newStorage StorageFactory) (*Harness, error) {
 	logger.Info("Creating new harness...")
+	clock, _ := cfg.Clock.(*raft.VirtualClock)
+	var network *raft.ChannelNetwork
+	// restarted nodes join the same network through h.cfg.
+	if cfg.NewTransport == nil {
+		if clock != nil && cfg.TickDriven {
+			// the harness hands over the messages itself, in order, each round.
+			network = raft.NewQueuedChannelNetwork(clock)
+			cfg.NewTransport = network.Transport
+		} else {
+			cfg.NewTransport = raft.NewChannelNetwork().Transport
+		}
+	}
+	if cfg.Links == nil {
+		cfg.Links = raft.NewLinks(cfg.Seed)
+	}
+	timeScale := max(1, float64(cfg.ElectionTimeoutMin)/float64(raft.DefaultConfig().ElectionTimeoutMin))
+	if cfg.Logger == nil {
+		cfg.Logger = eventLogger(clock)
+	}
+
+	// every node reads time through a clock of its own, so it can be skewed.
+	cfg.Overrides = maps.Clone(cfg.Overrides)
+	if cfg.Overrides == nil {
+		cfg.Overrides = make(map[int]raft.Config)
+	}
+	safety := newSafetyChecker(cfg.Logger)
+	cfg.Observer = safety
+	clocks := make(map[int]*raft.SkewedClock)
+	for i := range n {
+		if clock := skewableClock(&cfg, i); clock != nil {
+			clocks[i] = clock
+		}
+	}
 
 	kvss := make([]*server.KVService, n)
 	ready := make(chan any)
 	connected := make([]bool, n)
 	alive := make([]bool, n)
-	storage := make([]*raft.MapStorage, n)
+	storage := make([]raft.Storage, n)
 
 	ports := portManager.NextPortRange(n)
 
@@ -71,8 +285,16 @@ func NewHarness(n int, c *clit.Client) *Harness {
 			}
 		}
 
-		storage[i] = raft.NewMapStorage()
-		kvss[i] = server.New(i, peerIds, storage[i], ready, c)
+		storage[i] = openStorage(newStorage, i)
+		kvs, err := server.New(i, peerIds, storage[i], ready, cfg, c)
+		if err != nil {
+			// every node checks the same cfg, so only the first one fails.
+			if closer, ok := storage[i].(io.Closer); ok {
+				closer.Close()
+			}
+			return nil, err
+		}
+		kvss[i] = kvs
 		alive[i] = true
 	}
 
@@ -84,7 +306,9 @@ func NewHarness(n int, c *clit.Client) *Harness {
 		}
 		connected[i] = true
 	}
-	time.Sleep(500 * time.Millisecond)
+	if clock == nil {
+		time.Sleep(time.Duration(500 * float64(time.Millisecond) * timeScale))
+	}
 	close(ready)
 
 	kvServiceAddrs := make([]string, n)
@@ -102,17 +326,40 @@ func NewHarness(n int, c *clit.Client) *Harness {
 		connected:      connected,
 		alive:          alive,
 		storage:        storage,
+		newStorage:     newStorage,
+		diskFaults:     make(map[int]raft.DiskFaults),
 		ctx:            ctx,
 		ctxCancel:      ctxCancel,
 		c:              c,
+		cfg:            cfg,
+		timeScale:      timeScale,
+		stopTicks:      make(chan struct{}),
+		clock:          clock,
+		network:        network,
+		clocks:         clocks,
+		logger:         cfg.Logger,
+		safety:         safety,
+		stopSafety:     make(chan struct{}),
+		safetyDone:     make(chan struct{}),
+	}
+	if cfg.TickDriven && network == nil {
+		go h.runTicks()
 	}
+	go h.watchSafety()
 
 	logger.Info("New harness created")
 
-	return h
+	return h, nil
 }
 
-func (h *Harness) Shutdown() {
+// Shutdown stops the cluster and returns the first Raft safety guarantee it
+// broke, unless the scenario expected one: ending a scenario with it is what
+// turns a broken guarantee into a failure.
+func (h *Harness) Shutdown() error {
+	close(h.stopSafety)
+	<-h.safetyDone
+	h.checkLogs()
+
 	for i := range h.kvCluster {
 		h.kvCluster[i].DisconnectFromAllRaftPeers()
 		h.connected[i] = false
@@ -124,15 +371,183 @@ func (h *Harness) Shutdown() {
 	for i := range h.kvCluster {
 		if h.alive[i] {
 			h.alive[i] = false
-			if err := h.kvCluster[i].Shutdown(); err != nil {
+			// no handoff: nodes waiting on virtual time with nobody
+			// running rounds would never finish it.
+			if err := h.kvCluster[i].Stop(); err != nil {
 				logger.Error("Error shutting down server", zap.Int("serverID", i), zap.Error(err))
 			} else {
 				logger.Info("Server shut down successfully", zap.Int("serverID", i))
 			}
+			h.closeStorage(i)
 		}
 	}
 
+	close(h.stopTicks)
+
 	logger.Info("Shutdown complete for Harness", zap.String("harness", fmt.Sprintf("%p", h)))
+
+	if h.safetyExpected() {
+		return nil
+	}
+	return h.SafetyViolation()
+}
+
+// runTicks drives a TickDriven cluster the harness doesn't run rounds for
+// until Shutdown: each round advances the virtual clock, if any, by one
+// TickInterval and ticks every node. Under a real clock a round takes
+// TickInterval; under a virtual one only virtualTickPause.
+func (h *Harness) runTicks() {
+	pause := h.cfg.TickInterval
+	if h.clock != nil {
+		pause = virtualTickPause
+	}
+	for {
+		select {
+		case <-h.stopTicks:
+			return
+		default:
+		}
+
+		if h.clock != nil {
+			h.clock.Advance(h.cfg.TickInterval)
+		}
+		h.tickMu.Lock()
+		for _, kvs := range h.kvCluster {
+			kvs.Tick()
+		}
+		h.tickMu.Unlock()
+		time.Sleep(pause)
+	}
+}
+
+// round advances the virtual clock by one TickInterval, ticks every node in
+// id order and delivers the messages due by then.
+func (h *Harness) round() {
+	h.tickMu.Lock()
+	h.clock.Advance(h.cfg.TickInterval)
+	for _, kvs := range h.kvCluster {
+		kvs.Tick()
+	}
+	h.network.Deliver()
+	h.tickMu.Unlock()
+	if h.inFlight.Load() > 0 {
+		time.Sleep(virtualTickPause)
+	}
+}
+
+// runUntil runs rounds until the virtual clock reaches deadline.
+func (h *Harness) runUntil(deadline time.Time) time.Time {
+	for h.clock.Now().Before(deadline) {
+		h.round()
+	}
+	return h.clock.Now()
+}
+
+// await runs op, which waits on the cluster, and returns once it has. When
+// the harness runs rounds itself it keeps running them meanwhile, or op
+// would wait forever; op counts as a client request under way.
+func (h *Harness) await(op func()) {
+	if h.network == nil {
+		op()
+		return
+	}
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		op()
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			h.round()
+		}
+	}
+}
+
+// SetPreVote toggles the PreVote round on every live node, and on any node
+// restarted or added afterwards.
+func (h *Harness) SetPreVote(enabled bool) {
+	h.preVote = enabled
+	for i := range h.kvCluster {
+		if h.alive[i] {
+			h.kvCluster[i].SetPreVote(enabled)
+		}
+	}
+	h.logger.Info().
+		Bool("preVote", enabled).
+		Msg("preVoteConfigured")
+}
+
+// SetLeaseRead toggles leader lease reads with the given clock drift bound on
+// every live node, and on any node restarted or added afterwards.
+func (h *Harness) SetLeaseRead(enabled bool, maxClockDrift float64) {
+	h.leaseRead = enabled
+	h.maxClockDrift = maxClockDrift
+	for i := range h.kvCluster {
+		if h.alive[i] {
+			h.kvCluster[i].SetLeaseRead(enabled, maxClockDrift)
+		}
+	}
+	h.logger.Info().
+		Bool("leaseRead", enabled).
+		Float64("maxClockDrift", maxClockDrift).
+		Msg("leaseReadConfigured")
+}
+
+// scaled stretches d by the harness' time scale.
+func (h *Harness) scaled(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * h.timeScale)
+}
+
+// sleepMs sleeps n milliseconds of default-speed cluster time, on the
+// virtual clock when there is one.
+func (h *Harness) sleepMs(n int) {
+	d := h.scaled(time.Duration(n) * time.Millisecond)
+	if h.network != nil {
+		h.runUntil(h.clock.Now().Add(d))
+		return
+	}
+	if h.clock != nil {
+		<-h.clock.After(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// now reads the harness' clock: the virtual one when there is one.
+func (h *Harness) now() time.Time {
+	if h.clock != nil {
+		return h.clock.Now()
+	}
+	return time.Now()
+}
+
+// sleepUntil sleeps until d of default-speed cluster time has passed since
+// start and returns the time it woke at. Waking at a set time rather than
+// after a set sleep keeps whatever ran before from pushing it back.
+func (h *Harness) sleepUntil(start time.Time, d time.Duration) time.Time {
+	deadline := start.Add(h.scaled(d))
+	if h.network != nil {
+		return h.runUntil(deadline)
+	}
+	if h.clock != nil {
+		return <-h.clock.Until(deadline)
+	}
+	time.Sleep(time.Until(deadline))
+	return time.Now()
+}
+
+// newKVClient creates a client whose per-service retry timeout follows the
+// cluster's time scale.
+func (h *Harness) newKVClient(addrs []string, c *clit.Client) *client.KVClient {
+	kc := client.New(addrs, c)
+	kc.SetRetryTimeout(h.scaled(50 * time.Millisecond))
+	kc.SetLogger(h.logger)
+	return kc
 }
 
 func (h *Harness) NewClient(c *clit.Client) *client.KVClient {
@@ -142,60 +557,111 @@ func (h *Harness) NewClient(c *clit.Client) *client.KVClient {
 			addrs = append(addrs, h.kvServiceAddrs[i])
 		}
 	}
-	return client.New(addrs, c)
+	return h.newKVClient(addrs, c)
 }
 
-func (h *Harness) CheckSingleLeader() int {
+// CheckSingleLeader returns the id of the one leader among the connected
+// nodes, or an error if they don't settle on one.
+func (h *Harness) CheckSingleLeader() (int, error) {
+	var ids []int
+	for i := range h.n {
+		if h.connected[i] {
+			ids = append(ids, i)
+		}
+	}
+	return h.CheckSingleLeaderIn(ids)
+}
+
+// CheckSingleLeaderIn is CheckSingleLeader looking at the nodes in ids only,
+// e.g. one side of a partition.
+func (h *Harness) CheckSingleLeaderIn(ids []int) (int, error) {
+	var leaders []int
 	for r := 0; r < 8; r++ {
-		leaderId := -1
-		for i := range h.n {
-			if h.connected[i] && h.kvCluster[i].IsLeader() {
-				if leaderId < 0 {
-					leaderId = i
-				} else {
-
-					return -1
-				}
+		leaders = leaders[:0]
+		for _, i := range ids {
+			if h.kvCluster[i].IsLeader() {
+				leaders = append(leaders, i)
 			}
 		}
-		if leaderId >= 0 {
-			return leaderId
+		// a deposed leader may not have heard of the next term yet.
+		if len(leaders) == 1 {
+			return leaders[0], nil
 		}
-		time.Sleep(500 * time.Millisecond)
+		h.sleepMs(500)
 	}
-	return -1
+	if len(leaders) > 1 {
+		return -1, fmt.Errorf("nodes %v all lead among %v", leaders, ids)
+	}
+	return -1, fmt.Errorf("no leader elected among %v", ids)
+}
+
+// put is c.Put run through await.
+func (h *Harness) put(ctx context.Context, c *client.KVClient, key, value string) (pv string, found bool, err error) {
+	h.await(func() { pv, found, err = c.Put(ctx, key, value) })
+	return pv, found, err
+}
+
+// get is c.Get run through await.
+func (h *Harness) get(ctx context.Context, c *client.KVClient, key string) (v string, found bool, err error) {
+	h.await(func() { v, found, err = c.Get(ctx, key) })
+	return v, found, err
 }
 
-func (h *Harness) CheckPut(c *client.KVClient, key, value string) (string, bool) {
-	ctx, cancel := context.WithTimeout(h.ctx, 300*time.Millisecond)
+// getWithMode is c.GetWithMode run through await.
+func (h *Harness) getWithMode(ctx context.Context, c *client.KVClient, key string, mode types.ReadMode) (v string, found bool, err error) {
+	h.await(func() { v, found, err = c.GetWithMode(ctx, key, mode) })
+	return v, found, err
+}
+
+// CheckPut writes key=value and returns the value it replaced, if any.
+func (h *Harness) CheckPut(c *client.KVClient, key, value string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, h.scaled(300*time.Millisecond))
 	defer cancel()
-	pv, f, err := c.Put(ctx, key, value)
+	pv, f, err := h.put(ctx, c, key, value)
 	if err != nil {
-		logger.Error("Put error", zap.String("key", key), zap.String("value", value), zap.Error(err))
-		return pv, f
+		return pv, f, fmt.Errorf("put %s=%s: %w", key, value, err)
 	}
-	return pv, f
+	return pv, f, nil
 }
 
-func (h *Harness) CheckGet(c *client.KVClient, key string, wantValue string) {
-	ctx, cancel := context.WithTimeout(h.ctx, 500*time.Millisecond)
+// CheckGet reads key and fails unless it holds wantValue.
+func (h *Harness) CheckGet(c *client.KVClient, key string, wantValue string) error {
+	ctx, cancel := context.WithTimeout(h.ctx, h.scaled(500*time.Millisecond))
 	defer cancel()
-	gv, f, err := c.Get(ctx, key)
-	if err != nil {
-		logger.Error("Get error", zap.String("key", key), zap.Error(err))
-		return
+	gv, f, err := h.get(ctx, c, key)
+	switch {
+	case err != nil:
+		return fmt.Errorf("get %s: %w", key, err)
+	case !f:
+		return fmt.Errorf("get %s: key not found, want %s", key, wantValue)
+	case gv != wantValue:
+		return fmt.Errorf("get %s: got %s, want %s", key, gv, wantValue)
 	}
-	if !f {
-		logger.Warn("Key not found", zap.String("key", key))
-		return
+	return nil
+}
+
+// checkPuts writes key<i>=value<i> for every i in [from, to) through c.
+func (h *Harness) checkPuts(c *client.KVClient, from, to int) error {
+	for i := from; i < to; i++ {
+		if _, _, err := h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i)); err != nil {
+			return err
+		}
 	}
-	if gv != wantValue {
-		logger.Warn("Value mismatch for key", zap.String("key", key), zap.String("got", gv), zap.String("want", wantValue))
+	return nil
+}
+
+// checkGets reads back what checkPuts wrote for every i in [from, to).
+func (h *Harness) checkGets(c *client.KVClient, from, to int) error {
+	for i := from; i < to; i++ {
+		if err := h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i)); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func (h *Harness) DisconnectServiceFromPeers(id int) {
-	// log.Info().
+	// h.logger.Info().
 	// 	Int("raftID", id).
 	// 	Msg("serviceDisconnecting")
 
@@ -206,7 +672,7 @@ func (h *Harness) DisconnectServiceFromPeers(id int) {
 		}
 	}
 	h.connected[id] = false
-	// log.Info().
+	// h.logger.Info().
 	// 	Int("raftID", id).
 	// 	Msg("serviceDisconnected")
 }
@@ -215,44 +681,219 @@ func (h *Harness) ReconnectServiceToPeers(id int) {
 	for j := 0; j < h.n; j++ {
 		if j != id && h.alive[j] {
 			if err := h.kvCluster[id].ConnectToRaftPeer(j, h.kvCluster[j].GetRaftListenAddr()); err != nil {
-				log.Error().Err(err).Int("service_id", id).Int("peer_id", j).
+				h.logger.Error().Err(err).Int("service_id", id).Int("peer_id", j).
 					Msg("Failed to connect service to peer")
 				return
 			}
 			if err := h.kvCluster[j].ConnectToRaftPeer(id, h.kvCluster[id].GetRaftListenAddr()); err != nil {
-				log.Error().Err(err).Int("service_id", id).Int("peer_id", j).
+				h.logger.Error().Err(err).Int("service_id", id).Int("peer_id", j).
 					Msg("Failed to connect peer to service")
 				return
 			}
 		}
 	}
 	h.connected[id] = true
-	log.Info().
+	h.logger.Info().
 		Int("raftID", id).
 		Msg("serviceReconnected")
 }
 
+// SetLinkFault makes messages sent from node from to node to misbehave as
+// f until changed; the link the other way is left as it is. A zero f heals
+// the link.
+func (h *Harness) SetLinkFault(from, to int, f raft.LinkFault) {
+	h.cfg.Links.Set(from, to, f)
+	h.logger.Info().
+		Int("raftID", from).
+		Int("peer", to).
+		Float64("drop", f.Drop).
+		Dur("latency", f.Latency).
+		Dur("jitter", f.Jitter).
+		Int("bandwidth", f.Bandwidth).
+		Float64("duplicate", f.Duplicate).
+		Float64("reorder", f.Reorder).
+		Dur("reorderWindow", f.ReorderWindow).
+		Msg("linkFaultSet")
+}
+
+// ClearLinkFaults heals every link between nodes.
+func (h *Harness) ClearLinkFaults() {
+	h.cfg.Links.Reset()
+	h.logger.Info().Msg("linkFaultsCleared")
+}
+
+// Partition splits the network into groups whose nodes only reach each
+// other, replacing any cuts in place. Nodes in no group are cut off from
+// everyone.
+func (h *Harness) Partition(groups ...[]int) {
+	group := make(map[int]int)
+	for g, ids := range groups {
+		for _, id := range ids {
+			group[id] = g + 1
+		}
+	}
+	h.cfg.Links.Heal()
+	for from := range h.n {
+		for to := range h.n {
+			if from != to && (group[from] == 0 || group[from] != group[to]) {
+				h.cfg.Links.Cut(from, to)
+			}
+		}
+	}
+	h.logger.Info().
+		Interface("groups", groups).
+		Interface("cuts", h.cfg.Links.Cuts()).
+		Msg("networkPartitioned")
+}
+
+// CutLink stops messages from node from reaching node to, on top of any
+// cuts in place. Messages the other way still get through, so to keeps
+// hearing from from but none of its replies arrive.
+func (h *Harness) CutLink(from, to int) {
+	h.cfg.Links.Cut(from, to)
+	h.logger.Info().
+		Int("raftID", from).
+		Int("peer", to).
+		Interface("cuts", h.cfg.Links.Cuts()).
+		Msg("linkCut")
+}
+
+// Bridge splits the network into left and right, which can't reach each
+// other, with bridge the only node reaching both sides. It replaces any cuts
+// in place.
+func (h *Harness) Bridge(bridge int, left, right []int) {
+	h.cfg.Links.Heal()
+	for _, l := range left {
+		for _, r := range right {
+			h.cfg.Links.Cut(l, r)
+			h.cfg.Links.Cut(r, l)
+		}
+	}
+	h.logger.Info().
+		Int("bridge", bridge).
+		Ints("left", left).
+		Ints("right", right).
+		Interface("cuts", h.cfg.Links.Cuts()).
+		Msg("networkBridged")
+}
+
+// Heal restores every link cut by Partition, CutLink or Bridge. Faults set
+// with SetLinkFault stay.
+func (h *Harness) Heal() {
+	h.cfg.Links.Heal()
+	h.logger.Info().Msg("networkHealed")
+}
+
+// PauseService freezes node id for d, like a long GC pause or a stalled VM:
+// it stays connected but handles nothing, then carries on from where it
+// stopped. It returns right away.
+func (h *Harness) PauseService(id int, d time.Duration) {
+	if err := h.kvCluster[id].Pause(h.scaled(d)); err != nil {
+		logger.Error("Cannot pause service", zap.Int("service_id", id), zap.Error(err))
+	}
+}
+
+// SetClockSkew makes node id's clock run rate times as fast as everyone
+// else's and read offset ahead of it, across restarts too. Its election
+// timeouts, heartbeats and leases all run on that clock. SetClockSkew(id, 1, 0)
+// puts it back in step.
+func (h *Harness) SetClockSkew(id int, rate float64, offset time.Duration) {
+	clock := h.clocks[id]
+	if clock == nil {
+		logger.Error("Cannot skew the clock cfg.Overrides gave this service", zap.Int("service_id", id))
+		return
+	}
+	offset = h.scaled(offset)
+	if err := clock.SetSkew(rate, offset); err != nil {
+		logger.Error("Cannot skew clock", zap.Int("service_id", id), zap.Error(err))
+		return
+	}
+	h.logger.Info().
+		Int("raftID", id).
+		Float64("rate", rate).
+		Dur("offset", offset).
+		Msg("clockSkewSet")
+}
+
+// SetDiskFaults makes node id's disk misbehave as f until changed, across
+// restarts too. A zero f heals it.
+func (h *Harness) SetDiskFaults(id int, f raft.DiskFaults) {
+	f.WriteLatency = h.scaled(f.WriteLatency)
+	h.diskFaults[id] = f
+	if fs, ok := h.storage[id].(*raft.FaultyStorage); ok {
+		fs.SetFaults(f)
+	}
+	h.logger.Info().
+		Int("raftID", id).
+		Dur("writeLatency", f.WriteLatency).
+		Int("unsynced", f.Unsynced).
+		Bool("tornWrites", f.TornWrites).
+		Ints("readErrors", f.ReadErrors).
+		Msg("diskFaultsSet")
+}
+
 func (h *Harness) CrashService(id int) {
-	// log.Info().
+	h.CrashServiceLosingWrites(id, 0)
+}
+
+// CrashServiceLosingWrites is CrashService on a machine losing power: up to
+// lose of the latest writes the node's disk acknowledged but had not synced,
+// as set with SetDiskFaults, are gone when it restarts.
+func (h *Harness) CrashServiceLosingWrites(id int, lose int) {
+	// h.logger.Info().
 	// 	Int("raftID", id).
 	// 	Msg("serviceCrashing")
 	h.DisconnectServiceFromPeers(id)
 	h.alive[id] = false
-	if err := h.kvCluster[id].Shutdown(); err != nil {
-		log.Error().Err(err).Int("service_id", id).Msg("Error while shutting down service")
+	if err := h.kvCluster[id].Stop(); err != nil {
+		h.logger.Error().Err(err).Int("service_id", id).Msg("Error while shutting down service")
 		return
 	}
-	log.Info().
+	if fs, ok := h.storage[id].(*raft.FaultyStorage); ok && lose > 0 {
+		lost, torn, err := fs.Crash(lose)
+		if err != nil {
+			logger.Error("Error crashing storage", zap.Int("serverID", id), zap.Error(err))
+		}
+		h.logger.Info().
+			Int("raftID", id).
+			Int("lostWrites", lost).
+			Bool("tornWrite", torn).
+			Msg("writesLost")
+	}
+	h.closeStorage(id)
+	h.logger.Info().
 		Int("raftID", id).
 		Msg("serviceCrashed")
 }
 
+// ShutdownService stops a node gracefully: unlike CrashService a leader
+// first hands leadership off while it can still reach its peers.
+func (h *Harness) ShutdownService(id int) {
+	if h.kvCluster[id].IsLeader() {
+		var err error
+		h.await(func() { err = h.kvCluster[id].TransferLeadership(-1) })
+		if err != nil {
+			logger.Warn("Leadership handoff before shutdown failed", zap.Int("service_id", id), zap.Error(err))
+		}
+	}
+	h.DisconnectServiceFromPeers(id)
+	h.alive[id] = false
+	// the handoff is done, or failed with the peers still reachable.
+	if err := h.kvCluster[id].Stop(); err != nil {
+		h.logger.Error().Err(err).Int("service_id", id).Msg("Error while shutting down service")
+	}
+	h.closeStorage(id)
+	h.logger.Info().
+		Int("raftID", id).
+		Msg("serviceShutdown")
+}
+
 func (h *Harness) RestartService(id int) {
 	if h.alive[id] {
 		logger.Error("Cannot restart: service is still alive", zap.Int("service_id", id))
 		return
 	}
-	// log.Info().
+	// h.logger.Info().
 	// 	Int("raftID", id).
 	// 	Msg("serviceReconnecting")
 	peerIds := make([]int, 0)
@@ -263,20 +904,170 @@ func (h *Harness) RestartService(id int) {
 	}
 	ready := make(chan any)
 
+	// Reopen the storage the way a restarted process would.
+	h.storage[id] = h.openStorage(id)
+
 	// Create a new KVService instance with a client
-	h.kvCluster[id] = server.New(id, peerIds, h.storage[id], ready, h.c)
-	h.kvCluster[id].ServeHTTP(14200 + id)
+	kvs, err := server.New(id, peerIds, h.storage[id], ready, h.cfg, h.c)
+	if err != nil {
+		logger.Error("Error restarting server", zap.Int("serverID", id), zap.Error(err))
+		h.closeStorage(id)
+		return
+	}
+	h.tickMu.Lock()
+	h.kvCluster[id] = kvs
+	h.tickMu.Unlock()
+	h.kvCluster[id].SetPreVote(h.preVote)
+	h.kvCluster[id].SetLeaseRead(h.leaseRead, h.maxClockDrift)
+	port := portManager.NextPortRange(1)[0]
+	h.kvServiceAddrs[id] = fmt.Sprintf("localhost:%d", port)
+	h.kvCluster[id].ServeHTTP(port)
 
 	h.ReconnectServiceToPeers(id)
 	close(ready)
 	h.alive[id] = true
 
-	time.Sleep(20 * time.Millisecond)
-	log.Info().
+	h.sleepMs(20)
+	h.logger.Info().
 		Int("raftID", id).
 		Msg("serviceRestarted")
 }
 
+// TransferLeadership asks the current leader to hand leadership to target
+// and returns the id of the leader afterwards.
+func (h *Harness) TransferLeadership(target int) (int, error) {
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return -1, err
+	}
+	h.await(func() { err = h.kvCluster[lid].TransferLeadership(target) })
+	if err != nil {
+		return -1, fmt.Errorf("transferring leadership from %d to %d: %w", lid, target, err)
+	}
+	h.sleepMs(100)
+	return h.CheckSingleLeader()
+}
+
+// AddService starts a new node with the next free id, connects it to every
+// live node and has the leader add it as a voter. It returns the new id.
+func (h *Harness) AddService() (int, error) {
+	id, err := h.startJoiningService()
+	if err != nil {
+		return -1, err
+	}
+	err = h.changeConfig(id, func(kvs *server.KVService) (int, error) {
+		return kvs.AddVoter(id)
+	})
+	if err != nil {
+		return id, err
+	}
+	h.logger.Info().
+		Int("raftID", id).
+		Msg("serviceAdded")
+	return id, nil
+}
+
+// AddLearner starts a new node like AddService but has the leader add it as
+// a non-voting learner. It returns the new id.
+func (h *Harness) AddLearner() (int, error) {
+	id, err := h.startJoiningService()
+	if err != nil {
+		return -1, err
+	}
+	err = h.changeConfig(id, func(kvs *server.KVService) (int, error) {
+		return kvs.AddLearner(id)
+	})
+	if err != nil {
+		return id, err
+	}
+	h.logger.Info().
+		Int("raftID", id).
+		Msg("learnerAdded")
+	return id, nil
+}
+
+// PromoteLearner has the leader turn the learner id into a voter, retrying
+// while the learner is still catching up.
+func (h *Harness) PromoteLearner(id int) error {
+	return h.changeConfig(id, func(kvs *server.KVService) (int, error) {
+		return kvs.PromoteLearner(id)
+	})
+}
+
+// startJoiningService starts a node with the next free id outside the
+// configuration and connects it to every live node. It returns the new id.
+func (h *Harness) startJoiningService() (int, error) {
+	id := h.n
+	peerIds := make([]int, 0)
+	for p := range h.n {
+		peerIds = append(peerIds, p)
+	}
+	ready := make(chan any)
+	port := portManager.NextPortRange(1)[0]
+
+	h.storage = append(h.storage, h.openStorage(id))
+	if clock := skewableClock(&h.cfg, id); clock != nil {
+		h.clocks[id] = clock
+	}
+	kvs, err := server.NewJoining(id, peerIds, h.storage[id], ready, h.cfg, h.c)
+	if err != nil {
+		h.closeStorage(id)
+		h.storage = h.storage[:id]
+		return -1, fmt.Errorf("starting joining server %d: %w", id, err)
+	}
+	h.tickMu.Lock()
+	h.kvCluster = append(h.kvCluster, kvs)
+	h.tickMu.Unlock()
+	h.kvCluster[id].SetPreVote(h.preVote)
+	h.kvCluster[id].SetLeaseRead(h.leaseRead, h.maxClockDrift)
+	h.kvServiceAddrs = append(h.kvServiceAddrs, fmt.Sprintf("localhost:%d", port))
+	h.alive = append(h.alive, true)
+	h.connected = append(h.connected, false)
+	h.n++
+
+	h.kvCluster[id].ServeHTTP(port)
+	h.ReconnectServiceToPeers(id)
+	close(ready)
+	return id, nil
+}
+
+// RemoveService has the leader remove id from the voters, then shuts the
+// node down once the cluster had time to commit the change.
+func (h *Harness) RemoveService(id int) error {
+	err := h.changeConfig(id, func(kvs *server.KVService) (int, error) {
+		return kvs.RemoveVoter(id)
+	})
+	if err != nil {
+		return err
+	}
+	h.sleepMs(300)
+
+	h.CrashService(id)
+	h.logger.Info().
+		Int("raftID", id).
+		Msg("serviceRemoved")
+	return nil
+}
+
+// changeConfig retries a membership change against the current leader until
+// it is accepted; only one change can be in flight at a time.
+func (h *Harness) changeConfig(id int, change func(kvs *server.KVService) (int, error)) error {
+	var err error
+	for attempts := 0; attempts < 10; attempts++ {
+		var lid int
+		if lid, err = h.CheckSingleLeader(); err == nil {
+			_, err = change(h.kvCluster[lid])
+			if err == nil || errors.Is(err, raft.ErrAlreadyMember) || errors.Is(err, raft.ErrNotMember) ||
+				errors.Is(err, raft.ErrNotLearner) {
+				return nil
+			}
+			logger.Warn("Configuration change rejected", zap.Int("service_id", id), zap.Error(err))
+		}
+		h.sleepMs(100)
+	}
+	return fmt.Errorf("configuration change for %d never accepted: %w", id, err)
+}
+
 func (h *Harness) NewClientWithRandomAddrsOrder() *client.KVClient {
 	var addrs []string
 	for i := range h.kvCluster {
@@ -287,32 +1078,57 @@ func (h *Harness) NewClientWithRandomAddrsOrder() *client.KVClient {
 	rand.Shuffle(len(addrs), func(i, j int) {
 		addrs[i], addrs[j] = addrs[j], addrs[i]
 	})
-	return client.New(addrs, h.c)
+	return h.newKVClient(addrs, h.c)
 }
 
 func (h *Harness) NewClientSingleService(id int) *client.KVClient {
 	addrs := h.kvServiceAddrs[id : id+1]
-	return client.New(addrs, h.c)
+	return h.newKVClient(addrs, h.c)
 }
 
-func (h *Harness) CheckGetNotFound(c *client.KVClient, key string) {
-	ctx, cancel := context.WithTimeout(h.ctx, 500*time.Millisecond)
+// CheckGetNotFound reads key and fails if it exists.
+func (h *Harness) CheckGetNotFound(c *client.KVClient, key string) error {
+	ctx, cancel := context.WithTimeout(h.ctx, h.scaled(500*time.Millisecond))
 	defer cancel()
-	_, f, err := c.Get(ctx, key)
-	if err != nil {
-		logger.Error("Get error", zap.String("key", key), zap.Error(err))
-		return
+	v, f, err := h.get(ctx, c, key)
+	switch {
+	case err != nil:
+		return fmt.Errorf("get %s: %w", key, err)
+	case f:
+		return fmt.Errorf("get %s: got %s, want key not found", key, v)
 	}
-	if f {
-		logger.Warn("Key unexpectedly found", zap.String("key", key))
+	return nil
+}
+
+// CheckGetTimesOut reads key and fails unless the read times out.
+func (h *Harness) CheckGetTimesOut(c *client.KVClient, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.scaled(300*time.Millisecond))
+	defer cancel()
+	_, _, err := h.get(ctx, c, key)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		return fmt.Errorf("get %s: got %v, want deadline exceeded", key, err)
 	}
+	return nil
 }
 
-func (h *Harness) CheckGetTimesOut(c *client.KVClient, key string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+// CheckPutTimesOut writes key=value and fails unless the write times out.
+func (h *Harness) CheckPutTimesOut(c *client.KVClient, key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.scaled(300*time.Millisecond))
 	defer cancel()
-	_, _, err := c.Get(ctx, key)
+	_, _, err := h.put(ctx, c, key, value)
 	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
-		logger.Error("Expected deadline exceeded error", zap.String("key", key), zap.Error(err))
+		return fmt.Errorf("put %s=%s: got %v, want deadline exceeded", key, value, err)
+	}
+	return nil
+}
+
+// CheckPutFails writes key=value and fails if the write commits, whether the
+// node it went to timed out or turned it down.
+func (h *Harness) CheckPutFails(c *client.KVClient, key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.scaled(300*time.Millisecond))
+	defer cancel()
+	if _, _, err := h.put(ctx, c, key, value); err == nil {
+		return fmt.Errorf("put %s=%s: committed, want it to fail", key, value)
 	}
+	return nil
 }
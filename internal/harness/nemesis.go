@@ -0,0 +1,333 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pro0o/raft-in-motion/internal/raft"
+)
+
+// NEMESIS
+// Instead of a fixed script, a nemesis draws a schedule of faults from a seed
+// and injects it into a running cluster while clients keep writing. Every
+// decision is made up front, when the schedule is drawn, so the schedule is
+// the whole record of a run: drawing it again from the seed, or decoding the
+// JSON logged with it, replays the same faults at the same times.
+
+// FaultKind names a step a nemesis can take.
+type FaultKind string
+
+const (
+	FaultCrash     FaultKind = "crash"     // crash a node, keeping a majority up
+	FaultRestart   FaultKind = "restart"   // restart a crashed node
+	FaultPartition FaultKind = "partition" // split the network in two, healed later
+	FaultPause     FaultKind = "pause"     // freeze a node for a while
+	FaultLinkDelay FaultKind = "linkDelay" // slow one link down, lifted later
+	FaultDisk      FaultKind = "disk"      // slow one node's disk down, lifted later
+
+	// FaultHeal ends a partition. Schedules heal their partitions themselves,
+	// so it is never on the menu.
+	FaultHeal FaultKind = "heal"
+)
+
+// AllFaults is every fault a nemesis can pick from.
+var AllFaults = []FaultKind{FaultCrash, FaultRestart, FaultPartition, FaultPause, FaultLinkDelay, FaultDisk}
+
+// NemesisStep is one fault a nemesis injects, or lifts.
+type NemesisStep struct {
+	// At is when the step is taken, since the run started, in default-speed
+	// cluster time.
+	At   time.Duration `json:"at"`
+	Kind FaultKind     `json:"kind"`
+
+	// Node is the node the step acts on; for a link delay the sending end
+	// of the link, Peer the receiving one.
+	Node int `json:"node"`
+	Peer int `json:"peer,omitempty"`
+
+	// Groups are the sides of a partition.
+	Groups [][]int `json:"groups,omitempty"`
+
+	// Duration is how long a pause lasts.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Latency is what a link delay or disk step adds to every message or
+	// write; 0 lifts it.
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// Schedule is a run of a nemesis against a cluster of Nodes nodes, in the
+// order its steps are taken.
+type Schedule struct {
+	Seed     int64         `json:"seed"`
+	Nodes    int           `json:"nodes"`
+	Duration time.Duration `json:"duration"`
+	Steps    []NemesisStep `json:"steps"`
+}
+
+// NewSchedule draws a schedule of faults from menu against an n-node cluster
+// lasting duration. The same arguments always give the same schedule.
+func NewSchedule(seed int64, n int, menu []FaultKind, duration time.Duration) (Schedule, error) {
+	for _, kind := range menu {
+		if !slices.Contains(AllFaults, kind) {
+			return Schedule{}, fmt.Errorf("unknown fault %q", kind)
+		}
+	}
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	between := func(lo, hi int) time.Duration {
+		return time.Duration(lo+rng.IntN(hi-lo+1)) * time.Millisecond
+	}
+
+	s := Schedule{Seed: seed, Nodes: n, Duration: duration}
+	// what the cluster looks like at t, so every step drawn makes sense then.
+	crashed := make([]bool, n)
+	pausedUntil := make([]time.Duration, n)
+	diskUntil := make([]time.Duration, n)
+	var partitionUntil, linkDelayUntil time.Duration
+	pick := func(ok func(id int) bool) []int {
+		var ids []int
+		for id := range n {
+			if ok(id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	for t := between(100, 400); t < duration; t += between(100, 400) {
+		up := pick(func(id int) bool { return !crashed[id] })
+		down := pick(func(id int) bool { return crashed[id] })
+		awake := pick(func(id int) bool { return !crashed[id] && pausedUntil[id] <= t })
+		fastDisks := pick(func(id int) bool { return diskUntil[id] <= t })
+
+		var possible []FaultKind
+		for _, kind := range menu {
+			switch {
+			case kind == FaultCrash && len(down) >= (n-1)/2,
+				kind == FaultRestart && len(down) == 0,
+				kind == FaultPartition && (n < 2 || partitionUntil > t),
+				kind == FaultPause && len(awake) == 0,
+				kind == FaultLinkDelay && (n < 2 || linkDelayUntil > t),
+				kind == FaultDisk && len(fastDisks) == 0:
+				continue
+			}
+			possible = append(possible, kind)
+		}
+		if len(possible) == 0 {
+			continue
+		}
+
+		step := NemesisStep{At: t, Kind: possible[rng.IntN(len(possible))]}
+		switch step.Kind {
+		case FaultCrash:
+			step.Node = up[rng.IntN(len(up))]
+			crashed[step.Node] = true
+		case FaultRestart:
+			step.Node = down[rng.IntN(len(down))]
+			crashed[step.Node] = false
+			pausedUntil[step.Node] = 0
+		case FaultPartition:
+			perm := rng.Perm(n)
+			k := 1 + rng.IntN(n-1)
+			left, right := perm[:k], perm[k:]
+			slices.Sort(left)
+			slices.Sort(right)
+			step.Groups = [][]int{left, right}
+			partitionUntil = t + between(200, 1000)
+			s.Steps = append(s.Steps, NemesisStep{At: partitionUntil, Kind: FaultHeal})
+		case FaultPause:
+			step.Node = awake[rng.IntN(len(awake))]
+			step.Duration = between(100, 500)
+			pausedUntil[step.Node] = t + step.Duration
+		case FaultLinkDelay:
+			step.Node = rng.IntN(n)
+			step.Peer = (step.Node + 1 + rng.IntN(n-1)) % n
+			step.Latency = between(5, 30)
+			linkDelayUntil = t + between(200, 1000)
+			s.Steps = append(s.Steps, NemesisStep{At: linkDelayUntil, Kind: FaultLinkDelay, Node: step.Node, Peer: step.Peer})
+		case FaultDisk:
+			step.Node = fastDisks[rng.IntN(len(fastDisks))]
+			step.Latency = between(1, 5)
+			diskUntil[step.Node] = t + between(200, 1000)
+			s.Steps = append(s.Steps, NemesisStep{At: diskUntil[step.Node], Kind: FaultDisk, Node: step.Node})
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	// a lift drawn early may come after faults drawn later.
+	slices.SortStableFunc(s.Steps, func(a, b NemesisStep) int {
+		return int(a.At - b.At)
+	})
+	return s, nil
+}
+
+// nemesisClients is how many clients write while a nemesis runs.
+const nemesisClients = 3
+
+// nemesisWorkload is the clients' view of a cluster under a nemesis and
+// what they got acknowledged. Every write goes to a key of its own, so each
+// acknowledged one must read back exactly.
+type nemesisWorkload struct {
+	mu     sync.Mutex
+	addrs  []string          // of the live nodes, as the nemesis leaves them
+	acked  map[string]string // acknowledged writes
+	failed int
+}
+
+func (w *nemesisWorkload) setAddrs(h *Harness) {
+	var addrs []string
+	for i := range h.n {
+		if h.alive[i] {
+			addrs = append(addrs, h.kvServiceAddrs[i])
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addrs = addrs
+}
+
+func (w *nemesisWorkload) write(h *Harness, ctx context.Context, key, value string) {
+	w.mu.Lock()
+	addrs := w.addrs
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.scaled(300*time.Millisecond))
+	defer cancel()
+	// RunNemesis runs the rounds meanwhile; see Harness.inFlight.
+	h.inFlight.Add(1)
+	_, _, err := h.newKVClient(addrs, h.c).Put(ctx, key, value)
+	h.inFlight.Add(-1)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		w.failed++
+		return
+	}
+	w.acked[key] = value
+}
+
+// RunNemesis takes the steps of s against the cluster while clients keep
+// writing, then lifts every fault, restarts the crashed nodes and checks
+// that no safety guarantee broke and every write the cluster acknowledged
+// reads back. Running a recorded schedule again replays it.
+func (h *Harness) RunNemesis(s Schedule) error {
+	if s.Nodes != h.n {
+		return fmt.Errorf("schedule for %d nodes run against %d", s.Nodes, h.n)
+	}
+	if data, err := json.Marshal(s); err == nil {
+		h.logger.Info().
+			Int64("seed", s.Seed).
+			Int("steps", len(s.Steps)).
+			RawJSON("schedule", data).
+			Msg("nemesisSchedule")
+	}
+
+	w := &nemesisWorkload{acked: make(map[string]string)}
+	w.setAddrs(h)
+	ctx, stop := context.WithCancel(h.ctx)
+	var wg sync.WaitGroup
+	for c := range nemesisClients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				w.write(h, ctx, fmt.Sprintf("nemesis%d-%d", c, i), fmt.Sprintf("value%d", i))
+			}
+		}()
+	}
+
+	h.nemesisTrace = nil
+	start := h.now()
+	for _, step := range s.Steps {
+		at := h.sleepUntil(start, step.At)
+		h.takeNemesisStep(step, at.Sub(start))
+		if step.Kind == FaultCrash || step.Kind == FaultRestart {
+			w.setAddrs(h)
+		}
+	}
+	end := h.sleepUntil(start, s.Duration).Sub(start)
+	stop()
+	wg.Wait()
+
+	// the lifts are taken once the clients are gone, as steps due when the
+	// run ended.
+	h.takeNemesisStep(NemesisStep{At: s.Duration, Kind: FaultHeal}, end)
+	h.ClearLinkFaults()
+	for id := range h.n {
+		if h.diskFaults[id].WriteLatency > 0 {
+			h.takeNemesisStep(NemesisStep{At: s.Duration, Kind: FaultDisk, Node: id}, end)
+		}
+	}
+	for id := range h.n {
+		if !h.alive[id] {
+			h.takeNemesisStep(NemesisStep{At: s.Duration, Kind: FaultRestart, Node: id}, end)
+		}
+	}
+	h.logger.Info().
+		Int("acked", len(w.acked)).
+		Int("failed", w.failed).
+		Msg("nemesisLifted")
+	if err := h.SafetyViolation(); err != nil {
+		return err
+	}
+	if _, err := h.CheckSingleLeader(); err != nil {
+		return fmt.Errorf("once the faults were lifted: %w", err)
+	}
+
+	keys := make([]string, 0, len(w.acked))
+	for key := range w.acked {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	c := h.NewClient(h.c)
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(h.ctx, h.scaled(500*time.Millisecond))
+		v, found, err := h.get(ctx, c, key)
+		cancel()
+		switch {
+		case err != nil:
+			return fmt.Errorf("reading acknowledged write %s: %w", key, err)
+		case !found:
+			return fmt.Errorf("acknowledged write %s=%s lost", key, w.acked[key])
+		case v != w.acked[key]:
+			return fmt.Errorf("acknowledged write %s=%s reads back as %s", key, w.acked[key], v)
+		}
+	}
+	return nil
+}
+
+// NemesisTrace returns the steps the last RunNemesis took, in order: those
+// of its schedule, then the lifts it ended on.
+func (h *Harness) NemesisTrace() []NemesisStep {
+	return slices.Clone(h.nemesisTrace)
+}
+
+func (h *Harness) takeNemesisStep(step NemesisStep, takenAt time.Duration) {
+	h.logger.Info().
+		Str("kind", string(step.Kind)).
+		Int64("atMs", step.At.Milliseconds()).
+		Int64("takenAtMs", takenAt.Milliseconds()).
+		Msg("nemesisStep")
+	h.nemesisTrace = append(h.nemesisTrace, step)
+	switch step.Kind {
+	case FaultCrash:
+		h.CrashService(step.Node)
+	case FaultRestart:
+		h.RestartService(step.Node)
+	case FaultPartition:
+		h.Partition(step.Groups...)
+	case FaultHeal:
+		h.Heal()
+	case FaultPause:
+		h.PauseService(step.Node, step.Duration)
+	case FaultLinkDelay:
+		h.SetLinkFault(step.Node, step.Peer, raft.LinkFault{Latency: h.scaled(step.Latency)})
+	case FaultDisk:
+		h.SetDiskFaults(step.Node, raft.DiskFaults{WriteLatency: step.Latency})
+	}
+}
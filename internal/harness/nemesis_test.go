@@ -0,0 +1,106 @@
+package harness
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pro0o/raft-in-motion/internal/logger"
+	"github.com/pro0o/raft-in-motion/internal/raft"
+)
+
+// go test ./internal/harness -run Nemesis -nemesis.seed=<seed> replays the
+// schedule a failed run logged.
+var nemesisSeed = flag.Int64("nemesis.seed", 0, "seed of the nemesis schedule; 0 draws a fresh one")
+
+func TestMain(m *testing.M) {
+	logger.Init()
+	os.Exit(m.Run())
+}
+
+func TestNemesisScheduleReplays(t *testing.T) {
+	s, err := NewSchedule(42, 5, AllFaults, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Steps) == 0 {
+		t.Fatal("schedule has no steps")
+	}
+	again, _ := NewSchedule(42, 5, AllFaults, 5*time.Second)
+	if !reflect.DeepEqual(s, again) {
+		t.Error("same seed drew a different schedule")
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded Schedule
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, recorded) {
+		t.Error("recorded schedule decodes differently")
+	}
+
+	if _, err := NewSchedule(42, 5, []FaultKind{FaultHeal}, time.Second); err == nil {
+		t.Error("heal accepted on the menu")
+	}
+}
+
+func TestNemesisTraceReplays(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a cluster twice")
+	}
+	s, err := NewSchedule(42, 5, AllFaults, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func() []NemesisStep {
+		h, err := NewHarness(s.Nodes, initClient(), VirtualTime(raft.DefaultConfig(), s.Seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Shutdown()
+		h.SetPreVote(true)
+		if err := h.RunNemesis(s); err != nil {
+			t.Fatalf("seed %d: %v", s.Seed, err)
+		}
+		return h.NemesisTrace()
+	}
+	first, second := run(), run()
+	if len(first) <= len(s.Steps) || !reflect.DeepEqual(first[:len(s.Steps)], s.Steps) {
+		t.Fatalf("took steps %+v, want the schedule's %+v and the lifts", first, s.Steps)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed took different steps:\n%+v\nthen:\n%+v", first, second)
+	}
+}
+
+func TestNemesis(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a cluster for seconds")
+	}
+	seed := *nemesisSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	n := 5
+	s, err := NewSchedule(seed, n, AllFaults, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHarness(n, initClient(), raft.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Shutdown()
+	h.SetPreVote(true)
+	if err := h.RunNemesis(s); err != nil {
+		t.Fatalf("seed %d: %v", seed, err)
+	}
+}
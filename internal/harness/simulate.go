@@ -1,18 +1,19 @@
 package harness
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/pro0o/raft-in-motion/internal/client"
+	"github.com/pro0o/raft-in-motion/internal/kv/types"
+	"github.com/pro0o/raft-in-motion/internal/raft"
 
 	"github.com/rs/zerolog/log"
 )
 
-func sleepMs(n int) {
-	time.Sleep(time.Duration(n) * time.Millisecond)
-}
-
 func initClient() *client.Client {
 	return &client.Client{
 		Send:   make(chan string),
@@ -24,79 +25,103 @@ func initClient() *client.Client {
 func setupHarness() {
 	log.Info().Msg("Running setup harness test...")
 	c := initClient()
-	h := NewHarness(3, c)
+	h, err := NewHarness(3, c, raft.DefaultConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create harness")
+		return
+	}
 	defer h.Shutdown()
-	sleepMs(80)
-	log.Info().Msg("Setup harness test completed")
+	h.sleepMs(80)
+	h.logger.Info().Msg("Setup harness test completed")
 }
 
 func clientRequestBeforeConsensus() {
 	log.Info().Msg("Running client request before consensus test...")
 	c := initClient()
-	h := NewHarness(3, c)
+	h, err := NewHarness(3, c, raft.DefaultConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create harness")
+		return
+	}
 	defer h.Shutdown()
-	sleepMs(10)
+	h.sleepMs(10)
 
 	c1 := h.NewClient(c)
-	prevValue, found := h.CheckPut(c1, "llave", "cosa")
-	log.Info().
+	prevValue, found, err := h.CheckPut(c1, "llave", "cosa")
+	h.logger.Info().
 		Str("key", "llave").
 		Str("value", "cosa").
 		Str("previousValue", prevValue).
 		Bool("found", found).
+		Err(err).
 		Msg("Put operation completed")
 
-	sleepMs(80)
-	log.Info().Msg("Client request before consensus test completed")
+	h.sleepMs(80)
+	h.logger.Info().Msg("Client request before consensus test completed")
 }
 
 func basicPutGetSingleClient() {
 	log.Info().Msg("Running basic put/get single client test...")
 	c := initClient()
-	h := NewHarness(3, c)
+	h, err := NewHarness(3, c, raft.DefaultConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create harness")
+		return
+	}
 	// defer h.Shutdown()
 
 	time.Sleep(5 * time.Second)
 
 	var leader int
 	for attempts := 0; attempts < 2; attempts++ {
-		leader = h.CheckSingleLeader()
-		if leader >= 0 {
+		leader, err = h.CheckSingleLeader()
+		if err == nil {
 			break
 		}
 		time.Sleep(time.Duration(attempts+1) * 300 * time.Millisecond)
 	}
 
-	if leader < 0 {
-		log.Error().Msg("Test failed: No leader elected")
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Test failed: No leader elected")
 		return
 	}
 
-	log.Info().Int("leaderId", leader).Msg("Found leader")
+	h.logger.Info().Int("leaderId", leader).Msg("Found leader")
 
 	c1 := h.NewClient(c)
-	prevValue, found := h.CheckPut(c1, "llave", "cosa")
-	log.Info().
+	prevValue, found, err := h.CheckPut(c1, "llave", "cosa")
+	h.logger.Info().
 		Str("key", "llave").
 		Str("value", "cosa").
 		Str("previousValue", prevValue).
 		Bool("found", found).
+		Err(err).
 		Msg("Put operation completed")
 
-	h.CheckGet(c1, "llave", "cosa")
-	sleepMs(80)
-	log.Info().Msg("Basic put/get single client test completed")
+	if err := h.CheckGet(c1, "llave", "cosa"); err != nil {
+		h.logger.Error().Err(err).Msg("Test failed: Get failed")
+	}
+	h.sleepMs(80)
+	h.logger.Info().Msg("Basic put/get single client test completed")
 }
 
 func Test5ServerConcurrentClientsPutsAndGets() {
 	log.Info().Msg("Running 5-server concurrent clients puts and gets test...")
 	c := initClient()
-	h := NewHarness(5, c)
+	h, err := NewHarness(5, c, raft.DefaultConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create harness")
+		return
+	}
 	// defer h.Shutdown()
 
 	// Wait for leader election
-	lid := h.CheckSingleLeader()
-	log.Info().Int("leaderId", lid).Msg("Leader elected")
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Test failed: No leader elected")
+		return
+	}
+	h.logger.Info().Int("leaderId", lid).Msg("Leader elected")
 
 	// Number of concurrent operations
 	n := 9
@@ -109,15 +134,19 @@ func Test5ServerConcurrentClientsPutsAndGets() {
 		go func(i int) {
 			defer func() { putDone <- true }()
 			c := h.NewClient(initClient())
-			prevValue, found := h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
+			prevValue, found, err := h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
+			if err != nil {
+				h.logger.Error().Err(err).Int("index", i).Msg("Put failed")
+				return
+			}
 			if found {
-				log.Error().
+				h.logger.Error().
 					Int("index", i).
 					Str("prevValue", prevValue).
 					Msg("Unexpected key found")
 				return
 			}
-			log.Info().
+			h.logger.Info().
 				Int("index", i).
 				Str("key", fmt.Sprintf("key%v", i)).
 				Str("value", fmt.Sprintf("value%v", i)).
@@ -138,8 +167,11 @@ func Test5ServerConcurrentClientsPutsAndGets() {
 		go func(i int) {
 			defer func() { getDone <- true }()
 			c := h.NewClient(initClient())
-			h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
-			log.Info().
+			if err := h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i)); err != nil {
+				h.logger.Error().Err(err).Int("index", i).Msg("Get failed")
+				return
+			}
+			h.logger.Info().
 				Int("index", i).
 				Str("key", fmt.Sprintf("key%v", i)).
 				Msg("Get operation completed")
@@ -151,32 +183,44 @@ func Test5ServerConcurrentClientsPutsAndGets() {
 		<-getDone
 	}
 
-	// log.Info().Msg("5-server concurrent clients puts and gets test completed")
+	// h.logger.Info().Msg("5-server concurrent clients puts and gets test completed")
 }
 
 func crashFollowerTest() {
 	log.Info().Msg("Running crash follower test...")
 	c := initClient()
-	h := NewHarness(3, c)
+	h, err := NewHarness(3, c, raft.DefaultConfig())
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create harness")
+		return
+	}
 	// defer h.Shutdown()
 
-	lid := h.CheckSingleLeader()
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Test failed: No leader elected")
+		return
+	}
 	time.Sleep(1 * time.Second)
-	// log.Info().Int("leaderId", lid).Msg("Initial leader identified")
+	// h.logger.Info().Int("leaderId", lid).Msg("Initial leader identified")
 
 	// Submit some PUT commands
 	n := 3
 	for i := 0; i < n; i++ {
 		c := h.NewClient(initClient())
-		prevValue, found := h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
+		prevValue, found, err := h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
+		if err != nil {
+			h.logger.Error().Err(err).Int("index", i).Msg("Put failed")
+			return
+		}
 		if found {
-			log.Error().
+			h.logger.Error().
 				Int("index", i).
 				Str("prevValue", prevValue).
 				Msg("Unexpected key found")
 			return
 		}
-		log.Info().
+		h.logger.Info().
 			Int("index", i).
 			Str("key", fmt.Sprintf("key%v", i)).
 			Str("value", fmt.Sprintf("value%v", i)).
@@ -185,65 +229,817 @@ func crashFollowerTest() {
 
 	// Crash a non-leader
 	otherId := (lid + 1) % 3
-	log.Info().
+	h.logger.Info().
 		Int("crashingId", otherId).
 		Msg("Crashing follower service")
 	h.CrashService(otherId)
 
 	// Test direct leader communication
-	log.Info().Msg("Testing direct leader communication...")
+	h.logger.Info().Msg("Testing direct leader communication...")
 	for i := 0; i < n; i++ {
 		c := h.NewClient(initClient())
-		h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
-		log.Info().
+		if err := h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i)); err != nil {
+			h.logger.Error().Err(err).Int("index", i).Msg("Get failed")
+			return
+		}
+		h.logger.Info().
 			Int("index", i).
 			Str("key", fmt.Sprintf("key%v", i)).
 			Msg("Direct leader get operation completed")
 	}
 
 	// Test communication with regithub.com/pro0o/raft-in-motion/internaling servers
-	log.Info().Msg("Testing communication with all regithub.com/pro0o/raft-in-motion/internaling servers...")
+	h.logger.Info().Msg("Testing communication with all regithub.com/pro0o/raft-in-motion/internaling servers...")
 	for i := 0; i < n; i++ {
 		c := h.NewClient(initClient())
-		h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
-		log.Info().
+		if err := h.CheckGet(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i)); err != nil {
+			h.logger.Error().Err(err).Int("index", i).Msg("Get failed")
+			return
+		}
+		h.logger.Info().
 			Int("index", i).
 			Str("key", fmt.Sprintf("key%v", i)).
 			Msg("Get operation through any server completed")
 	}
-	sleepMs(800)
+	h.sleepMs(800)
 
-	log.Info().Msg("Crash follower test completed")
+	h.logger.Info().Msg("Crash follower test completed")
 }
 
-func DisconnectLeaderTest() {
+func DisconnectLeaderTest(cfg raft.Config) (err error) {
 	// logger.Info("simulatingDisconnectLeader")
 	c := initClient()
-	h := NewHarness(3, c)
-	defer h.Shutdown()
+	h, err := NewHarness(3, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
 
-	lid := h.CheckSingleLeader()
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
 
 	n := 4
-	for i := 0; i < n; i++ {
-		c := h.NewClient(initClient())
-		h.CheckPut(c, fmt.Sprintf("key%v", i), fmt.Sprintf("value%v", i))
+	if err := h.checkPuts(h.NewClient(c), 0, n); err != nil {
+		return err
 	}
 
-	log.Info().Int("raftID", lid).Msg("disconnectingLeader")
+	h.logger.Info().Int("raftID", lid).Msg("disconnectingLeader")
 	h.DisconnectServiceFromPeers(lid)
-	sleepMs(300)
+	h.sleepMs(300)
 
-	newlid := h.CheckSingleLeader()
+	newlid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
 	if newlid == lid {
-		log.Error().
-			Int("raftID", lid).
-			Msg("New leader is same as disconnected leader")
-		return
+		return fmt.Errorf("new leader is the disconnected leader %d", lid)
 	}
-	log.Info().Int("raftID", lid).Msg("reconnectingOriginalleader")
+	h.logger.Info().Int("raftID", lid).Msg("reconnectingOriginalleader")
 	h.ReconnectServiceToPeers(lid)
-	sleepMs(200)
+	h.sleepMs(200)
+
+	// h.logger.Info().Msg("disconnectLeaderTestCompleted")
+	return h.checkGets(h.NewClient(c), 0, n)
+}
+
+// MembershipChangeTest grows a 3-node cluster to 5 and shrinks it back,
+// writing keys between every step to show the cluster stays available.
+func MembershipChangeTest(cfg raft.Config) (err error) {
+	c := initClient()
+	h, err := NewHarness(3, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	if _, err := h.CheckSingleLeader(); err != nil {
+		return err
+	}
+
+	n := 3
+	if err := h.checkPuts(h.NewClient(c), 0, n); err != nil {
+		return err
+	}
+
+	h.logger.Info().Msg("growingCluster")
+	var added []int
+	for range 2 {
+		id, err := h.AddService()
+		if err != nil {
+			return err
+		}
+		added = append(added, id)
+	}
+	h.sleepMs(300)
+
+	if err := h.checkPuts(h.NewClient(c), n, 2*n); err != nil {
+		return err
+	}
+
+	h.logger.Info().Msg("shrinkingCluster")
+	for _, id := range added {
+		if err := h.RemoveService(id); err != nil {
+			return err
+		}
+	}
+	h.sleepMs(300)
+
+	return h.checkGets(h.NewClient(c), 0, 2*n)
+}
+
+// PreVoteTest isolates a follower long enough for it to time out over and
+// over, then reconnects it. It runs twice: without PreVote the returning
+// node's inflated term forces the healthy leader to step down, with PreVote
+// the node never bumped its term and the leader keeps going.
+func PreVoteTest(cfg raft.Config) error {
+	for _, preVote := range []bool{false, true} {
+		if err := isolateFollower(preVote, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isolateFollower(preVote bool, cfg raft.Config) (err error) {
+	c := initClient()
+	h, err := NewHarness(3, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(preVote)
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	fid := (lid + 1) % 3
+
+	h.logger.Info().Int("raftID", fid).Msg("disconnectingFollower")
+	h.DisconnectServiceFromPeers(fid)
+	h.sleepMs(1000)
+
+	h.logger.Info().Int("raftID", fid).Msg("reconnectingFollower")
+	h.ReconnectServiceToPeers(fid)
+	h.sleepMs(500)
 
-	// log.Info().Msg("disconnectLeaderTestCompleted")
+	newlid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	h.logger.Info().
+		Bool("preVote", preVote).
+		Int("oldLeader", lid).
+		Int("newLeader", newlid).
+		Msg("leaderAfterReconnect")
+	if preVote && newlid != lid {
+		return fmt.Errorf("leader changed from %d to %d after a follower came back despite PreVote", lid, newlid)
+	}
+	return nil
+}
+
+// LeadershipTransferTest moves leadership around the cluster on purpose,
+// then shuts the leader down gracefully so it hands off before exiting.
+func LeadershipTransferTest(cfg raft.Config) (err error) {
+	c := initClient()
+	h, err := NewHarness(3, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClient(c), 0, 2); err != nil {
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		target := (lid + 1) % 3
+		h.logger.Info().Int("raftID", lid).Int("peer", target).Msg("transferringLeadership")
+		if lid, err = h.TransferLeadership(target); err != nil {
+			return err
+		}
+		h.logger.Info().Int("raftID", lid).Msg("leaderAfterTransfer")
+		if lid != target {
+			return fmt.Errorf("leadership went to %d, want %d", lid, target)
+		}
+		h.sleepMs(300)
+	}
+
+	h.logger.Info().Int("raftID", lid).Msg("shuttingDownLeader")
+	h.ShutdownService(lid)
+	h.sleepMs(300)
+	newlid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	h.logger.Info().Int("raftID", newlid).Msg("leaderAfterShutdown")
+	return h.checkGets(h.NewClient(c), 0, 2)
+}
+
+// ReadModesTest compares the latency of log, ReadIndex and lease reads
+// against the same leader with leases enabled.
+func ReadModesTest(cfg raft.Config) (err error) {
+	c := initClient()
+	h, err := NewHarness(3, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetLeaseRead(true, 0.1)
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	c1 := h.NewClientSingleService(lid)
+	if _, _, err := h.CheckPut(c1, "llave", "cosa"); err != nil {
+		return err
+	}
+
+	n := 5
+	for _, mode := range []types.ReadMode{types.ReadModeLog, types.ReadModeIndex, types.ReadModeLease} {
+		var total time.Duration
+		for i := 0; i < n; i++ {
+			ctx, cancel := context.WithTimeout(h.ctx, h.scaled(500*time.Millisecond))
+			start := time.Now()
+			v, _, err := h.getWithMode(ctx, c1, "llave", mode)
+			total += time.Since(start)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("%s read: %w", mode, err)
+			}
+			if v != "cosa" {
+				return fmt.Errorf("%s read: got %s, want cosa", mode, v)
+			}
+		}
+		h.logger.Info().
+			Int("raftID", lid).
+			Str("mode", mode.String()).
+			Int64("avgMicros", total.Microseconds()/int64(n)).
+			Msg("readLatency")
+	}
+	return nil
+}
+
+// LearnerTest attaches a learner to a loaded cluster, keeps writing while it
+// catches up (commits never wait for it), then promotes it to a voter once
+// its log matches and reads everything back.
+func LearnerTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 3
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	if _, err := h.CheckSingleLeader(); err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClient(c), 0, 10); err != nil {
+		return err
+	}
+
+	id, err := h.AddLearner()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClient(c), 10, 20); err != nil {
+		return err
+	}
+
+	if err := h.PromoteLearner(id); err != nil {
+		return err
+	}
+	h.sleepMs(300)
+
+	return h.checkGets(h.NewClient(c), 0, 20)
+}
+
+// CrashRestartTest runs a cluster on write-ahead logs in a temporary
+// directory, crashes a follower and later every node, and restarts them from
+// what they wrote to disk. Every key written before the crashes must still
+// be there afterwards.
+func CrashRestartTest(cfg raft.Config) (err error) {
+	dir, err := os.MkdirTemp("", "raft-wal-")
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create WAL directory")
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	c := initClient()
+	n := 3
+	h, err := NewHarnessWithStorage(n, c, cfg, WALStorage(dir, raft.DefaultWALOptions()))
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClient(c), 0, 5); err != nil {
+		return err
+	}
+
+	follower := (lid + 1) % n
+	h.CrashService(follower)
+	if err := h.checkPuts(h.NewClient(c), 5, 10); err != nil {
+		return err
+	}
+	h.RestartService(follower)
+	h.sleepMs(300)
+
+	h.logger.Info().Msg("crashingCluster")
+	for id := range n {
+		h.CrashService(id)
+	}
+	for id := range n {
+		h.RestartService(id)
+	}
+	if _, err := h.CheckSingleLeader(); err != nil {
+		return err
+	}
+
+	return h.checkGets(h.NewClient(c), 0, 10)
+}
+
+// CrashPointTest runs a cluster through writes, a leader crash and
+// snapshots while recording every batch each node commits, then crashes each
+// node's WAL before every batch and in the middle of it. Recovery must always
+// find whole batches only.
+func CrashPointTest(cfg raft.Config) error {
+	dir, err := os.MkdirTemp("", "raft-crash-points-")
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot create WAL directory")
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	c := initClient()
+	newStorage, storage := recordingStorages()
+	h, err := NewHarnessWithStorage(3, c, cfg, newStorage)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		lid, err := h.CheckSingleLeader()
+		if err != nil {
+			return err
+		}
+		if err := h.checkPuts(h.NewClient(c), 0, 10); err != nil {
+			return err
+		}
+		h.CrashService(lid)
+		if _, err := h.CheckSingleLeader(); err != nil {
+			return err
+		}
+		if err := h.checkPuts(h.NewClient(c), 10, 25); err != nil {
+			return err
+		}
+		h.RestartService(lid)
+		h.sleepMs(300)
+		return nil
+	}()
+	err = errors.Join(err, h.Shutdown())
+
+	h.logCrashPoints(dir, storage)
+	return err
+}
+
+// LargeClusterTest runs a 25-node cluster on the in-process network: it
+// elects a leader, serves writes, survives losing that leader and reads
+// everything back. Clients go straight to the leader; walking 25 nodes to
+// find it would outlast their timeouts.
+func LargeClusterTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 25
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClientSingleService(lid), 0, 10); err != nil {
+		return err
+	}
+
+	h.CrashService(lid)
+	lid, err = h.CheckSingleLeader()
+	if err != nil {
+		return fmt.Errorf("after the leader crashed: %w", err)
+	}
+	cl := h.NewClientSingleService(lid)
+	if err := h.checkPuts(cl, 10, 20); err != nil {
+		return err
+	}
+	return h.checkGets(cl, 0, 20)
+}
+
+// LinkFaultTest runs writes over links that drop, delay, duplicate and
+// reorder messages, then cuts the link from the leader to one follower only:
+// the follower can still reach the leader but hears nothing back. With
+// PreVote it cannot win anyone over, so the leader stays in place and the
+// cluster keeps serving.
+func LinkFaultTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 3
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(true)
+
+	if _, err := h.CheckSingleLeader(); err != nil {
+		return err
+	}
+
+	flaky := raft.LinkFault{
+		Drop:          0.05,
+		Latency:       2 * time.Millisecond,
+		Jitter:        8 * time.Millisecond,
+		Bandwidth:     1 << 20,
+		Duplicate:     0.1,
+		Reorder:       0.1,
+		ReorderWindow: 20 * time.Millisecond,
+	}
+	for from := range n {
+		for to := range n {
+			if from != to {
+				h.SetLinkFault(from, to, flaky)
+			}
+		}
+	}
+	if err := h.checkPuts(h.NewClient(c), 0, 10); err != nil {
+		return err
+	}
+	if err := h.checkGets(h.NewClient(c), 0, 10); err != nil {
+		return err
+	}
+	h.ClearLinkFaults()
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	follower := (lid + 1) % n
+	h.SetLinkFault(lid, follower, raft.LinkFault{Drop: 1})
+	h.sleepMs(1000)
+
+	if after, err := h.CheckSingleLeader(); err != nil || after != lid {
+		return errors.Join(fmt.Errorf("leader changed from %d to %d behind a one-way cut", lid, after), err)
+	}
+	if err := h.checkPuts(h.NewClient(c), 10, 15); err != nil {
+		return err
+	}
+
+	h.ClearLinkFaults()
+	h.sleepMs(300)
+	return h.checkGets(h.NewClient(c), 0, 15)
+}
+
+// PartitionTest cuts a 5-node cluster so the leader ends up in the minority.
+// The majority elects a new leader and keeps serving, while a write sent to
+// the old leader never commits and is gone once the network heals. A bridge
+// topology follows, where one node is all that links the two halves.
+func PartitionTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 5
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(true)
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClientSingleService(lid), 0, 5); err != nil {
+		return err
+	}
+
+	minority := []int{lid, (lid + 1) % n}
+	majority := []int{(lid + 2) % n, (lid + 3) % n, (lid + 4) % n}
+	h.Partition(minority, majority)
+
+	mid, err := h.CheckSingleLeaderIn(majority)
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClientSingleService(mid), 5, 10); err != nil {
+		return err
+	}
+	// the old leader can't reach a majority, so this never commits.
+	if err := h.CheckPutFails(h.NewClientSingleService(lid), "split", "brain"); err != nil {
+		return err
+	}
+
+	h.Heal()
+	h.sleepMs(500)
+	if err := h.checkGets(h.NewClient(c), 0, 10); err != nil {
+		return err
+	}
+	if err := h.CheckGetNotFound(h.NewClient(c), "split"); err != nil {
+		return err
+	}
+
+	lid, err = h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	// the leader's side and the bridge form a majority; the far side only
+	// hears from the leader through the bridge, and PreVote keeps it from
+	// deposing anyone.
+	bridge := (lid + 2) % n
+	h.Bridge(bridge, []int{lid, (lid + 1) % n}, []int{(lid + 3) % n, (lid + 4) % n})
+	if err := h.checkPuts(h.NewClientSingleService(lid), 10, 15); err != nil {
+		return err
+	}
+	h.sleepMs(1000)
+	if after, err := h.CheckSingleLeader(); err != nil || after != lid {
+		return errors.Join(fmt.Errorf("leader changed from %d to %d across the bridge", lid, after), err)
+	}
+
+	h.Heal()
+	h.sleepMs(300)
+	return h.checkGets(h.NewClient(c), 0, 15)
+}
+
+// PauseTest freezes the leader of a 3-node cluster long enough for the
+// others to elect a new one. The old leader wakes up still believing it
+// leads, can't commit the write it was sent while frozen and steps down.
+// A paused follower then wakes up with its election timer long expired, and
+// PreVote keeps it from deposing the leader.
+func PauseTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 3
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(true)
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	if err := h.checkPuts(h.NewClientSingleService(lid), 0, 5); err != nil {
+		return err
+	}
+
+	h.PauseService(lid, 1500*time.Millisecond)
+	nid, err := h.CheckSingleLeaderIn([]int{(lid + 1) % n, (lid + 2) % n})
+	if err != nil {
+		return fmt.Errorf("while the leader was paused: %w", err)
+	}
+	if err := h.checkPuts(h.NewClientSingleService(nid), 5, 10); err != nil {
+		return err
+	}
+	// the old leader only gets to this once it wakes up, in a stale term.
+	if err := h.CheckPutFails(h.NewClientSingleService(lid), "stale", "leader"); err != nil {
+		return err
+	}
+
+	h.sleepMs(1500)
+	if after, err := h.CheckSingleLeader(); err != nil || after != nid {
+		return errors.Join(fmt.Errorf("leader changed from %d to %d after the old leader woke up", nid, after), err)
+	}
+
+	follower := lid
+	h.PauseService(follower, 1000*time.Millisecond)
+	if err := h.checkPuts(h.NewClientSingleService(nid), 10, 15); err != nil {
+		return err
+	}
+	h.sleepMs(1500)
+	if after, err := h.CheckSingleLeader(); err != nil || after != nid {
+		return errors.Join(fmt.Errorf("leader changed from %d to %d after a follower woke up", nid, after), err)
+	}
+
+	if err := h.checkGets(h.NewClient(c), 0, 15); err != nil {
+		return err
+	}
+	return h.CheckGetNotFound(h.NewClient(c), "stale")
+}
+
+// DiskFaultTest shows why Raft persists log entries and votes before it
+// replies. A follower whose disk acknowledges writes it never synced helps
+// the leader commit a few writes, then loses them in a crash along with the
+// leader. The two nodes left elect a leader that never saw those writes, and
+// once the old leader is back its copy is overwritten: writes the cluster
+// acknowledged are gone. A node whose disk fails a read comes up Faulted and
+// recovers once the disk does.
+func DiskFaultTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 3
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	// a slow disk slows every write down, but loses nothing.
+	for id := range n {
+		h.SetDiskFaults(id, raft.DiskFaults{WriteLatency: 5 * time.Millisecond})
+	}
+	if err := h.checkPuts(h.NewClientSingleService(lid), 0, 5); err != nil {
+		return err
+	}
+	for id := range n {
+		h.SetDiskFaults(id, raft.DiskFaults{})
+	}
+
+	// a disk that loses acknowledged writes breaks what Raft assumes of it.
+	h.ExpectSafetyViolation()
+	liar, other := (lid+1)%n, (lid+2)%n
+	h.Partition([]int{lid, liar}, []int{other})
+	h.SetDiskFaults(liar, raft.DiskFaults{Unsynced: 1000, TornWrites: true})
+	for i := 0; i < 5; i++ {
+		if _, _, err := h.CheckPut(h.NewClientSingleService(lid), fmt.Sprintf("acked%v", i), fmt.Sprintf("value%v", i)); err != nil {
+			return err
+		}
+	}
+	h.CrashServiceLosingWrites(liar, 1000)
+	h.CrashService(lid)
+
+	h.Heal()
+	h.SetDiskFaults(liar, raft.DiskFaults{})
+	h.RestartService(liar)
+	nid, err := h.CheckSingleLeaderIn([]int{liar, other})
+	if err != nil {
+		return fmt.Errorf("after the crash: %w", err)
+	}
+	if _, _, err := h.CheckPut(h.NewClientSingleService(nid), "after", "crash"); err != nil {
+		return err
+	}
+	h.RestartService(lid)
+	h.sleepMs(500)
+
+	if err := h.checkGets(h.NewClient(c), 0, 5); err != nil {
+		return err
+	}
+	// what the scenario sets out to show: these were acknowledged, and lost.
+	for i := 0; i < 5; i++ {
+		if err := h.CheckGetNotFound(h.NewClient(c), fmt.Sprintf("acked%v", i)); err != nil {
+			return err
+		}
+	}
+	if err := h.CheckGet(h.NewClient(c), "after", "crash"); err != nil {
+		return err
+	}
+	if h.SafetyViolation() == nil {
+		return fmt.Errorf("the safety checker missed the lost writes")
+	}
+
+	// the first read of the restarted follower's state fails.
+	follower := liar
+	if follower == nid {
+		follower = other
+	}
+	h.SetDiskFaults(follower, raft.DiskFaults{ReadErrors: []int{1}})
+	h.CrashService(follower)
+	h.RestartService(follower)
+	if h.kvCluster[follower].Fault() == nil {
+		return fmt.Errorf("node %d came up despite a failed read", follower)
+	}
+	h.SetDiskFaults(follower, raft.DiskFaults{})
+	h.CrashService(follower)
+	h.RestartService(follower)
+	h.sleepMs(500)
+	if err := h.kvCluster[follower].Fault(); err != nil {
+		return fmt.Errorf("node %d still faulted on a healthy disk: %w", follower, err)
+	}
+	return h.CheckGet(h.NewClient(c), "after", "crash")
+}
+
+// ClockSkewTest runs nodes on clocks that disagree. A follower whose clock
+// runs fast keeps timing out: PreVote keeps it from deposing a healthy
+// leader, without PreVote it does. A leader whose clock falls behind once it
+// is partitioned away trusts its lease for too long and serves a stale read,
+// unless maxClockDrift is large enough to cover the skew.
+func ClockSkewTest(cfg raft.Config) (err error) {
+	c := initClient()
+	n := 3
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(true)
+
+	lid, err := h.CheckSingleLeader()
+	if err != nil {
+		return err
+	}
+	fast := (lid + 1) % n
+	h.SetClockSkew(fast, 8, 0)
+	h.sleepMs(1000)
+	if after, err := h.CheckSingleLeader(); err != nil || after != lid {
+		return errors.Join(fmt.Errorf("a fast follower clock changed the leader from %d to %d despite PreVote", lid, after), err)
+	}
+	h.SetPreVote(false)
+	h.sleepMs(1000)
+	if lid, err = h.CheckSingleLeader(); err != nil {
+		return err
+	}
+	h.logger.Info().Int("fast", fast).Int("raftID", lid).Msg("leaderAfterFastClock")
+	h.SetClockSkew(fast, 1, 0)
+	h.SetPreVote(true)
+
+	// the lease holds until a majority could have elected someone else, by
+	// the old leader's clock; when that clock runs slow the read is stale.
+	partitionedLeaseRead := func(key string) (string, error) {
+		lid, err := h.CheckSingleLeader()
+		if err != nil {
+			return "", err
+		}
+		if _, _, err := h.CheckPut(h.NewClientSingleService(lid), key, "old"); err != nil {
+			return "", err
+		}
+		others := []int{(lid + 1) % n, (lid + 2) % n}
+		h.Partition([]int{lid}, others)
+		h.SetClockSkew(lid, 0.1, 0)
+		defer func() {
+			h.Heal()
+			h.SetClockSkew(lid, 1, 0)
+			h.sleepMs(500)
+		}()
+		nid, err := h.CheckSingleLeaderIn(others)
+		if err != nil {
+			return "", fmt.Errorf("in the majority: %w", err)
+		}
+		if _, _, err := h.CheckPut(h.NewClientSingleService(nid), key, "new"); err != nil {
+			return "", err
+		}
+
+		ctx, cancel := context.WithTimeout(h.ctx, h.scaled(300*time.Millisecond))
+		defer cancel()
+		v, _, err := h.getWithMode(ctx, h.NewClientSingleService(lid), key, types.ReadModeLease)
+		h.logger.Info().Int("raftID", lid).Str("key", key).Str("value", v).Err(err).Msg("partitionedLeaseRead")
+		return v, err
+	}
+
+	h.SetLeaseRead(true, 0.1)
+	if v, err := partitionedLeaseRead("lease1"); err != nil || v != "old" {
+		return errors.Join(fmt.Errorf("expected the slow partitioned leader to serve a stale lease read, got %q", v), err)
+	}
+	// a 10x slower clock needs the lease cut by 90% and more.
+	h.SetLeaseRead(true, 0.95)
+	if v, err := partitionedLeaseRead("lease2"); err == nil {
+		return fmt.Errorf("the slow partitioned leader served lease read %q despite the drift margin", v)
+	}
+
+	if err := h.CheckGet(h.NewClient(c), "lease1", "new"); err != nil {
+		return err
+	}
+	return h.CheckGet(h.NewClient(c), "lease2", "new")
+}
+
+// NemesisTest lets a nemesis with every fault on the menu loose on a
+// five-node cluster for ten seconds while clients keep writing. The schedule
+// is drawn from cfg.Seed, or from a fresh seed it logs, to replay it with.
+func NemesisTest(cfg raft.Config) (err error) {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	c := initClient()
+	n := 5
+	h, err := NewHarness(n, c, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, h.Shutdown()) }()
+	h.SetPreVote(true)
+
+	s, err := NewSchedule(seed, n, AllFaults, 10*time.Second)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Test failed: cannot draw a nemesis schedule")
+		return err
+	}
+	if err = h.RunNemesis(s); err != nil {
+		h.logger.Error().Err(err).Int64("seed", seed).Msg("Test failed: the cluster broke under the nemesis")
+		return fmt.Errorf("nemesis seed %d: %w", seed, err)
+	}
+	return nil
 }
@@ -0,0 +1,65 @@
+package harness
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pro0o/raft-in-motion/internal/logger"
+	"github.com/pro0o/raft-in-motion/internal/raft"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSeedReplaysEvents(t *testing.T) {
+	run := func() []byte {
+		var events bytes.Buffer
+		output := logger.Output
+		logger.Output = zerolog.SyncWriter(&events)
+		defer func() { logger.Output = output }()
+
+		if err := PreVoteTest(VirtualTime(raft.DefaultConfig(), 7)); err != nil {
+			t.Fatal(err)
+		}
+		return events.Bytes()
+	}
+	first, second := run(), run()
+	if len(first) == 0 {
+		t.Fatal("scenario logged no events")
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("same seed logged different events:\n%s\nthen:\n%s", first, second)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs every scenario")
+	}
+	scenarios := []struct {
+		name string
+		run  func(cfg raft.Config) error
+	}{
+		{"DisconnectLeader", DisconnectLeaderTest},
+		{"MembershipChange", MembershipChangeTest},
+		{"PreVote", PreVoteTest},
+		{"LeadershipTransfer", LeadershipTransferTest},
+		{"ReadModes", ReadModesTest},
+		{"Learner", LearnerTest},
+		{"CrashRestart", CrashRestartTest},
+		{"CrashPoint", CrashPointTest},
+		{"LargeCluster", LargeClusterTest},
+		{"LinkFault", LinkFaultTest},
+		{"Partition", PartitionTest},
+		{"Pause", PauseTest},
+		{"DiskFault", DiskFaultTest},
+		{"ClockSkew", ClockSkewTest},
+		{"Nemesis", NemesisTest},
+	}
+	for _, tt := range scenarios {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.run(VirtualTime(raft.DefaultConfig(), 1)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
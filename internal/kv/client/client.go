@@ -12,6 +12,7 @@ import (
 	"github.com/pro0o/raft-in-motion/internal/client"
 	"github.com/pro0o/raft-in-motion/internal/kv/types"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,6 +23,8 @@ type KVClient struct {
 	assumedLeader int      // Index of the assumed leader in the cluster
 	clientID      int32    // Unique identifier for the client
 	client        *client.Client
+	retryTimeout  time.Duration // How long to wait on one service before trying the next
+	logger        *zerolog.Logger
 }
 
 func New(serviceAddrs []string, c *client.Client) *KVClient {
@@ -30,9 +33,23 @@ func New(serviceAddrs []string, c *client.Client) *KVClient {
 		assumedLeader: 0,
 		clientID:      clientCount.Add(1),
 		client:        c,
+		retryTimeout:  50 * time.Millisecond,
+		logger:        &log.Logger,
 	}
 }
 
+// SetRetryTimeout changes how long the client waits on a single service
+// before moving on to the next one, for clusters running slower than usual.
+func (c *KVClient) SetRetryTimeout(d time.Duration) {
+	c.retryTimeout = d
+}
+
+// SetLogger sends the client's events to logger instead of the global
+// log.Logger.
+func (c *KVClient) SetLogger(logger *zerolog.Logger) {
+	c.logger = logger
+}
+
 var clientCount atomic.Int32
 
 func (c *KVClient) Put(ctx context.Context, key string, value string) (string, bool, error) {
@@ -51,13 +68,13 @@ func (c *KVClient) Put(ctx context.Context, key string, value string) (string, b
 	err := c.send(ctx, "put", putReq, &putResp)
 
 	if err == nil {
-		log.Info().
+		c.logger.Info().
 			Int32("clientID", c.clientID).
 			Str("key", key).
 			Str("value", value).
 			Msg("putRequestCompleted")
 	} else {
-		log.Info().
+		c.logger.Info().
 			Int32("clientID", c.clientID).
 			Str("key", key).
 			Str("value", value).
@@ -68,8 +85,14 @@ func (c *KVClient) Put(ctx context.Context, key string, value string) (string, b
 }
 
 func (c *KVClient) Get(ctx context.Context, key string) (string, bool, error) {
+	return c.GetWithMode(ctx, key, types.ReadModeIndex)
+}
+
+// GetWithMode is Get with an explicit choice of how the leader confirms the read.
+func (c *KVClient) GetWithMode(ctx context.Context, key string, mode types.ReadMode) (string, bool, error) {
 	getReq := types.GetRequest{
-		Key: key,
+		Key:  key,
+		Mode: mode,
 	}
 	var getResp types.GetResponse
 
@@ -81,12 +104,12 @@ func (c *KVClient) Get(ctx context.Context, key string) (string, bool, error) {
 	err := c.send(ctx, "get", getReq, &getResp)
 
 	if err == nil {
-		log.Info().
+		c.logger.Info().
 			Int32("clientID", c.clientID).
 			Str("key", key).
 			Msg("getRequestCompleted")
 	} else {
-		log.Info().
+		c.logger.Info().
 			Int32("clientID", c.clientID).
 			Str("key", key).
 			Msg("getRequestFailed")
@@ -96,36 +119,48 @@ func (c *KVClient) Get(ctx context.Context, key string) (string, bool, error) {
 }
 
 func (c *KVClient) send(ctx context.Context, route string, req any, resp types.Response) error {
+	// back off once every service turned us down, e.g. mid-election; until
+	// then the leader may just be the next one.
+	rejections := 0
+	reject := func() {
+		rejections++
+		if rejections%len(c.addrs) == 0 {
+			time.Sleep(300 * time.Millisecond) // small backoff
+		}
+		c.assumedLeader = (c.assumedLeader + 1) % len(c.addrs)
+	}
+
 FindLeader:
 	for {
-		retryCtx, retryCtxCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		retryCtx, retryCtxCancel := context.WithTimeout(ctx, c.retryTimeout)
 		path := fmt.Sprintf("http://%s/%s/", c.addrs[c.assumedLeader], route)
 
 		if err := sendJSONRequest(retryCtx, path, req, resp); err != nil {
 			if contextDone(ctx) {
 				retryCtxCancel()
-				return nil
+				return ctx.Err()
 			} else if contextDeadlineExceeded(retryCtx) {
 				c.assumedLeader = (c.assumedLeader + 1) % len(c.addrs)
 				retryCtxCancel()
 				continue FindLeader
 			}
+			// unreachable, e.g. crashed.
+			reject()
 			retryCtxCancel()
-			return nil
+			continue FindLeader
 		}
 
 		switch resp.Status() {
 		case types.StatusNotLeader:
-			log.Info().
+			c.logger.Info().
 				Int32("clientID", c.clientID).
 				Str("server", c.addrs[c.assumedLeader]).
 				Msg("responseNotLeader")
-			time.Sleep(300 * time.Millisecond) // small backoff
-			c.assumedLeader = (c.assumedLeader + 1) % len(c.addrs)
+			reject()
 			retryCtxCancel()
 			continue FindLeader
 		case types.StatusOK:
-			log.Info().
+			c.logger.Info().
 				Int32("clientID", c.clientID).
 				Str("server", c.addrs[c.assumedLeader]).
 				Msg("foundLeader")
@@ -6,6 +6,7 @@ package server
 import (
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -19,6 +20,10 @@ import (
 
 const DebugKV = 1
 
+// SnapshotThreshold is how many applied entries the service lets pile up in
+// the Raft log before handing Raft a snapshot of the DataStore.
+const SnapshotThreshold = 16
+
 // KVService represents a key-value store service that operates as part of a Raft cluster.
 // It provides HTTP endpoints for external requests and manages data consistency via
 // Raft consensus. Each instance of KVService corresponds to a single node in the cluster.
@@ -32,18 +37,42 @@ type KVService struct {
 	ds         *DataStore                    // The underlying key-value data store (state machine).
 	srv        *http.Server                  // The HTTP server used to expose this service to external clients.
 	client     *client.Client
+
+	lastSnapshotIndex int           // Log index covered by the most recent snapshot taken or restored.
+	appliedIndex      int           // Highest log index applied to ds.
+	appliedNotify     chan struct{} // Closed and replaced whenever appliedIndex moves.
 }
 
 // New initializes a new KVService instance for the given node ID and its peers.
 // It registers the Command struct for gob encoding, sets up the commit channel,
 // and creates a Raft server to handle Raft-related RPCs. It then launches the Raft server
-// and returns the initialized KVService.
-func New(id int, peerIds []int, storage raft.Storage, readyChan <-chan any, c *client.Client) *KVService {
-	gob.Register(Command{})
+// and returns the initialized KVService. It fails if cfg is not a valid Raft
+// configuration.
+func New(id int, peerIds []int, storage raft.Storage, readyChan <-chan any, cfg raft.Config, c *client.Client) (*KVService, error) {
+	commitChan := make(chan raft.CommitEntry)
+	rs, err := raft.NewServer(id, peerIds, storage, readyChan, commitChan, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	return newService(id, rs, commitChan, c), nil
+}
+
+// NewJoining is like New but the node starts outside the cluster formed by
+// peerIds; it only takes part in elections and commits after the leader adds
+// it with AddVoter.
+func NewJoining(id int, peerIds []int, storage raft.Storage, readyChan <-chan any, cfg raft.Config, c *client.Client) (*KVService, error) {
 	commitChan := make(chan raft.CommitEntry)
+	rs, err := raft.NewJoiningServer(id, peerIds, storage, readyChan, commitChan, cfg, c)
+	if err != nil {
+		return nil, err
+	}
+	return newService(id, rs, commitChan, c), nil
+}
+
+func newService(id int, rs *raft.Server, commitChan chan raft.CommitEntry, c *client.Client) *KVService {
+	gob.Register(Command{})
 
 	// Raft server setup, this node will start accepting RPC connections from peers
-	rs := raft.NewServer(id, peerIds, storage, readyChan, commitChan, c)
 	rs.Serve()
 
 	kvs := &KVService{
@@ -53,6 +82,10 @@ func New(id int, peerIds []int, storage raft.Storage, readyChan <-chan any, c *c
 		ds:         NewDataStore(),
 		commitSubs: make(map[int]chan raft.CommitEntry),
 		client:     c,
+
+		lastSnapshotIndex: -1,
+		appliedIndex:      -1,
+		appliedNotify:     make(chan struct{}),
 	}
 
 	// Start the commit updater that handles updates to the replicated state machine.
@@ -63,6 +96,73 @@ func New(id int, peerIds []int, storage raft.Storage, readyChan <-chan any, c *c
 func (kvs *KVService) IsLeader() bool {
 	return kvs.rs.IsLeader()
 }
+
+// SetPreVote enables or disables the Raft PreVote round on this node.
+func (kvs *KVService) SetPreVote(enabled bool) {
+	kvs.rs.SetPreVote(enabled)
+}
+
+// TransferLeadership moves leadership to target, or to the most up-to-date
+// voter if target is negative.
+func (kvs *KVService) TransferLeadership(target int) error {
+	return kvs.rs.TransferLeadership(target)
+}
+
+// SetLeaseRead enables or disables leader lease reads on this node.
+func (kvs *KVService) SetLeaseRead(enabled bool, maxClockDrift float64) {
+	kvs.rs.SetLeaseRead(enabled, maxClockDrift)
+}
+
+// AddVoter adds peerId to the cluster; only succeeds on the leader.
+func (kvs *KVService) AddVoter(peerId int) (int, error) {
+	return kvs.rs.AddVoter(peerId)
+}
+
+// RemoveVoter removes peerId from the cluster; only succeeds on the leader.
+func (kvs *KVService) RemoveVoter(peerId int) (int, error) {
+	return kvs.rs.RemoveVoter(peerId)
+}
+
+// AddLearner adds peerId as a non-voting learner; only succeeds on the leader.
+func (kvs *KVService) AddLearner(peerId int) (int, error) {
+	return kvs.rs.AddLearner(peerId)
+}
+
+// PromoteLearner makes the learner peerId a voter once it caught up; only
+// succeeds on the leader.
+func (kvs *KVService) PromoteLearner(peerId int) (int, error) {
+	return kvs.rs.PromoteLearner(peerId)
+}
+
+// Fault returns the storage error that faulted this node's Raft, or nil.
+func (kvs *KVService) Fault() error {
+	return kvs.rs.Fault()
+}
+
+// Pause freezes this node's Raft for d, as a long GC pause would.
+func (kvs *KVService) Pause(d time.Duration) error {
+	return kvs.rs.Pause(d)
+}
+
+// Inspect returns a copy of this node's Raft log.
+func (kvs *KVService) Inspect() raft.LogView {
+	return kvs.rs.Inspect()
+}
+
+// Tick advances a TickDriven node by one step.
+func (kvs *KVService) Tick() {
+	kvs.rs.Tick()
+}
+
+// Configuration returns the voter set this node currently uses.
+func (kvs *KVService) Configuration() []int {
+	return kvs.rs.Configuration()
+}
+
+// Learners returns the learner set this node currently uses.
+func (kvs *KVService) Learners() []int {
+	return kvs.rs.Learners()
+}
 func (kvs *KVService) ServeHTTP(port int) {
 	if kvs.srv != nil {
 		panic("ServeHTTP called with existing server")
@@ -76,11 +176,21 @@ func (kvs *KVService) ServeHTTP(port int) {
 		Handler: mux,
 	}
 
+	// listen before returning, so clients can reach the service right away.
+	ln, err := net.Listen("tcp", kvs.srv.Addr)
+	if err != nil {
+		kvs.kvlog("listening failed", map[string]interface{}{
+			"address": kvs.srv.Addr,
+			"error":   err.Error(),
+		})
+		kvs.srv = nil
+		return
+	}
 	go func() {
 		kvs.kvlog("serving HTTP", map[string]interface{}{
 			"address": kvs.srv.Addr,
 		})
-		if err := kvs.srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := kvs.srv.Serve(ln); err != http.ErrServerClosed {
 			//log.Fatal()
 		}
 		kvs.srv = nil
@@ -93,6 +203,20 @@ func (kvs *KVService) ServeHTTP(port int) {
 func (kvs *KVService) Shutdown() error {
 	kvs.kvlog("shutting down Raft server", nil)
 	kvs.rs.Shutdown()
+	return kvs.stopServing()
+}
+
+// Stop shuts the service down like Shutdown, but without the Raft server
+// handing off leadership first, as a crash would.
+func (kvs *KVService) Stop() error {
+	kvs.kvlog("stopping Raft server", nil)
+	kvs.rs.Stop()
+	return kvs.stopServing()
+}
+
+// stopServing closes the commit channel of a stopped Raft server, then stops
+// the HTTP server.
+func (kvs *KVService) stopServing() error {
 	kvs.kvlog("closing commitChan", nil)
 	close(kvs.commitChan)
 
@@ -111,8 +235,8 @@ func (kvs *KVService) Shutdown() error {
 }
 
 // handlePut processes "PUT" requests from clients, which store a key-value pair in the store.
-// The key-value pair is submitted as a Raft command, which is replicated across the cluster.
-// Once the command is committed, it updates the state machine, and the client receives a response.
+// The key-value pair is proposed as a Raft command, which is replicated across the cluster.
+// Once the command is applied to the state machine, the client receives a response.
 func (kvs *KVService) handlePut(w http.ResponseWriter, req *http.Request) {
 	pr := &types.PutRequest{}
 	if err := readRequestJSON(req, pr); err != nil {
@@ -129,35 +253,29 @@ func (kvs *KVService) handlePut(w http.ResponseWriter, req *http.Request) {
 		Value: pr.Value,
 		Id:    kvs.id,
 	}
-	logIndex := kvs.rs.Submit(cmd)
-
-	// If not the leader, respond with a "not leader" status.
-	if logIndex < 0 {
+	result, err := kvs.propose(req.Context(), cmd)
+	switch {
+	case err == nil:
+		renderJSON(w, types.PutResponse{
+			RespStatus: types.StatusOK,
+			KeyFound:   result.ResultFound,
+			PrevValue:  result.ResultValue,
+		})
+	case errors.Is(err, raft.ErrNotLeader):
 		renderJSON(w, types.PutResponse{RespStatus: types.StatusNotLeader})
+	case errors.Is(err, raft.ErrTimeout):
 		return
-	}
-
-	sub := kvs.createCommitSubsciption(logIndex)
-	select {
-	case entry := <-sub:
-		entryCmd := entry.Command.(Command)
-		// Verify if the command was committed by this node.
-		if entryCmd.Id == kvs.id {
-			renderJSON(w, types.PutResponse{
-				RespStatus: types.StatusOK,
-				KeyFound:   entryCmd.ResultFound,
-				PrevValue:  entryCmd.ResultValue,
-			})
-		} else {
-			renderJSON(w, types.PutResponse{RespStatus: types.StatusFailedCommit})
-		}
-	case <-req.Context().Done():
-		return
+	default:
+		renderJSON(w, types.PutResponse{RespStatus: types.StatusFailedCommit})
 	}
 }
 
 // handleGet processes "GET" requests from clients, retrieving the value for a given key.
-// The request is submitted as a Raft command, and the result is returned to the client once committed.
+// Depending on the request's ReadMode the read is confirmed with a leader lease or
+// Raft's ReadIndex and answered from the local DataStore, or submitted as a Raft
+// command with the result returned to the client once committed. A lease read falls
+// back to ReadIndex when the lease has expired, and a ReadIndex read falls back to
+// the log while a fresh leader can't offer a read index yet.
 func (kvs *KVService) handleGet(w http.ResponseWriter, req *http.Request) {
 	gr := &types.GetRequest{}
 	if err := readRequestJSON(req, gr); err != nil {
@@ -168,34 +286,102 @@ func (kvs *KVService) handleGet(w http.ResponseWriter, req *http.Request) {
 		"key": gr.Key,
 	})
 
+	readIndex, err := kvs.readIndex(gr.Mode)
+	switch {
+	case gr.Mode == types.ReadModeLog:
+		// straight to the log below.
+	case err == nil:
+		if !kvs.waitApplied(req.Context(), readIndex) {
+			return
+		}
+		value, found := kvs.ds.Get(gr.Key)
+		renderJSON(w, types.GetResponse{
+			RespStatus: types.StatusOK,
+			KeyFound:   found,
+			Value:      value,
+		})
+		return
+	case errors.Is(err, raft.ErrReadIndexNotReady):
+		// fall through to a log read below.
+	case errors.Is(err, raft.ErrTimeout):
+		renderJSON(w, types.GetResponse{RespStatus: types.StatusFailedCommit})
+		return
+	default:
+		renderJSON(w, types.GetResponse{RespStatus: types.StatusNotLeader})
+		return
+	}
+
 	cmd := Command{
 		Kind: CommandGet,
 		Key:  gr.Key,
 		Id:   kvs.id,
 	}
-	logIndex := kvs.rs.Submit(cmd)
-
-	if logIndex < 0 {
+	result, err := kvs.propose(req.Context(), cmd)
+	switch {
+	case err == nil:
+		renderJSON(w, types.GetResponse{
+			RespStatus: types.StatusOK,
+			KeyFound:   result.ResultFound,
+			Value:      result.ResultValue,
+		})
+	case errors.Is(err, raft.ErrNotLeader):
 		renderJSON(w, types.GetResponse{RespStatus: types.StatusNotLeader})
+	case errors.Is(err, raft.ErrTimeout):
 		return
+	default:
+		renderJSON(w, types.GetResponse{RespStatus: types.StatusFailedCommit})
+	}
+}
+
+// propose submits cmd through Raft and waits until it has been applied to ds,
+// returning the command with its result filled in. Errors are the proposal's:
+// ErrNotLeader, ErrLeadershipLost, ErrStopped, or ErrTimeout once ctx is done.
+func (kvs *KVService) propose(ctx context.Context, cmd Command) (Command, error) {
+	// runUpdater pops subscriptions under the same lock, so the entry can't
+	// be applied before its subscription is in place.
+	kvs.Lock()
+	p := kvs.rs.Propose(ctx, cmd)
+	var sub chan raft.CommitEntry
+	if p.Index() >= 0 {
+		sub = kvs.createCommitSubsciption(p.Index())
+	}
+	kvs.Unlock()
+	if sub == nil {
+		_, _, err := p.Wait()
+		return Command{}, err
 	}
 
-	sub := kvs.createCommitSubsciption(logIndex)
+	if _, _, err := p.Wait(); err != nil {
+		kvs.popCommitSubscription(p.Index())
+		return Command{}, err
+	}
+
+	// Raft reports the entry applied once it is handed to runUpdater, which
+	// fills in the result right after.
 	select {
-	case entry := <-sub:
-		entryCmd := entry.Command.(Command)
-		if entryCmd.Id == kvs.id {
-			renderJSON(w, types.GetResponse{
-				RespStatus: types.StatusOK,
-				KeyFound:   entryCmd.ResultFound,
-				Value:      entryCmd.ResultValue,
-			})
-		} else {
-			renderJSON(w, types.GetResponse{RespStatus: types.StatusFailedCommit})
+	case entry, ok := <-sub:
+		// A closed subscription means the entry was folded into a snapshot.
+		if !ok {
+			return Command{}, raft.ErrLeadershipLost
+		}
+		return entry.Command.(Command), nil
+	case <-ctx.Done():
+		return Command{}, raft.ErrTimeout
+	}
+}
+
+// readIndex gets the index ds must reach before a read in the given mode can be
+// served locally.
+func (kvs *KVService) readIndex(mode types.ReadMode) (int, error) {
+	switch mode {
+	case types.ReadModeLog:
+		return -1, nil
+	case types.ReadModeLease:
+		if readIndex, err := kvs.rs.LeaseRead(); err == nil {
+			return readIndex, nil
 		}
-	case <-req.Context().Done():
-		return
 	}
+	return kvs.rs.ReadIndex()
 }
 
 // runUpdater continuously listens for committed entries from the Raft commit channel.
@@ -204,6 +390,39 @@ func (kvs *KVService) handleGet(w http.ResponseWriter, req *http.Request) {
 func (kvs *KVService) runUpdater() {
 	go func() {
 		for entry := range kvs.commitChan {
+			if entry.Snapshot != nil {
+				if err := kvs.ds.Restore(entry.Snapshot); err != nil {
+					panic(fmt.Errorf("restoring snapshot at index %d: %w", entry.Index, err))
+				}
+				kvs.lastSnapshotIndex = entry.Index
+				kvs.setApplied(entry.Index)
+				kvs.dropCommitSubscriptions(entry.Index)
+				continue
+			}
+
+			if entry.LeaderChange {
+				// a new leader's no-op, nothing to apply.
+				kvs.kvlog("leader change committed", map[string]interface{}{
+					"index": entry.Index,
+					"term":  entry.Term,
+				})
+				kvs.setApplied(entry.Index)
+				continue
+			}
+
+			if cfg, ok := entry.Command.(raft.ConfigEntry); ok {
+				// membership changes are Raft's business, nothing to apply.
+				kvs.kvlog("configuration committed", map[string]interface{}{
+					"index":  entry.Index,
+					"voters": cfg.Voters,
+				})
+				kvs.setApplied(entry.Index)
+				if sub := kvs.popCommitSubscription(entry.Index); sub != nil {
+					close(sub)
+				}
+				continue
+			}
+
 			cmd := entry.Command.(Command)
 
 			// Process the command according to its type.
@@ -215,6 +434,7 @@ func (kvs *KVService) runUpdater() {
 			default:
 				panic(fmt.Errorf("unexpected command %v", cmd))
 			}
+			kvs.setApplied(entry.Index)
 
 			newEntry := raft.CommitEntry{
 				Command: cmd,
@@ -226,17 +446,62 @@ func (kvs *KVService) runUpdater() {
 				sub <- newEntry
 				close(sub)
 			}
+
+			if entry.Index-kvs.lastSnapshotIndex >= SnapshotThreshold {
+				kvs.takeSnapshot(entry.Index)
+			}
 		}
 	}()
 }
 
+// setApplied records that everything up to logIndex is reflected in ds and
+// wakes up reads waiting in waitApplied.
+func (kvs *KVService) setApplied(logIndex int) {
+	kvs.Lock()
+	defer kvs.Unlock()
+
+	kvs.appliedIndex = logIndex
+	close(kvs.appliedNotify)
+	kvs.appliedNotify = make(chan struct{})
+}
+
+// waitApplied blocks until ds reflects logIndex, or ctx is done.
+func (kvs *KVService) waitApplied(ctx context.Context, logIndex int) bool {
+	for {
+		kvs.Lock()
+		applied, notify := kvs.appliedIndex, kvs.appliedNotify
+		kvs.Unlock()
+
+		if applied >= logIndex {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// takeSnapshot hands Raft the DataStore as of logIndex so it can compact its log.
+func (kvs *KVService) takeSnapshot(logIndex int) {
+	snapshot, err := kvs.ds.Snapshot()
+	if err != nil {
+		kvs.kvlog("snapshot failed", map[string]interface{}{
+			"index": logIndex,
+			"error": err.Error(),
+		})
+		return
+	}
+	kvs.rs.Snapshot(logIndex, snapshot)
+	kvs.lastSnapshotIndex = logIndex
+}
+
 // createCommitSubscription sets up a subscription for a specific log index.
 // It allows the handler to be notified when the Raft log entry at that index is committed.
 // The result is a single-use channel that will deliver the entry when ready.
+// Expects kvs to be locked.
 func (kvs *KVService) createCommitSubsciption(logIndex int) chan raft.CommitEntry {
-	kvs.Lock()
-	defer kvs.Unlock()
-
 	if _, exists := kvs.commitSubs[logIndex]; exists {
 		panic(fmt.Sprintf("duplicate commit subscription for logIndex=%d", logIndex))
 	}
@@ -257,6 +522,20 @@ func (kvs *KVService) popCommitSubscription(logIndex int) chan raft.CommitEntry
 	return ch
 }
 
+// dropCommitSubscriptions closes every subscription at or below logIndex. Those
+// entries were replaced by an installed snapshot and will never be delivered.
+func (kvs *KVService) dropCommitSubscriptions(logIndex int) {
+	kvs.Lock()
+	defer kvs.Unlock()
+
+	for idx, ch := range kvs.commitSubs {
+		if idx <= logIndex {
+			close(ch)
+			delete(kvs.commitSubs, idx)
+		}
+	}
+}
+
 // kvlog logs a message if the DebugKV flag is enabled.
 func (kvs *KVService) kvlog(event string, details map[string]interface{}) {
 	if DebugKV > 0 {
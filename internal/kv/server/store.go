@@ -1,7 +1,11 @@
 // Basic in-memory datastore backing the KV service.
 package server
 
-import "sync"
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+)
 
 // a simple, concurrency-safe key-value store used as a backend
 // for kvservice.
@@ -32,3 +36,29 @@ func (ds *DataStore) Put(key, value string) (string, bool) {
 	ds.data[key] = value
 	return v, ok
 }
+
+// Snapshot gob-encodes the whole store so Raft can compact its log.
+func (ds *DataStore) Snapshot() ([]byte, error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ds.data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore replaces the contents of the store with a snapshot produced by
+// Snapshot.
+func (ds *DataStore) Restore(snapshot []byte) error {
+	data := make(map[string]string)
+	if err := gob.NewDecoder(bytes.NewBuffer(snapshot)).Decode(&data); err != nil {
+		return err
+	}
+
+	ds.Lock()
+	defer ds.Unlock()
+	ds.data = data
+	return nil
+}
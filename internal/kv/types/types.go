@@ -22,7 +22,27 @@ func (pr *PutResponse) Status() ResponseStatus {
 
 // get
 type GetRequest struct {
-	Key string
+	Key  string
+	Mode ReadMode
+}
+
+// how the leader makes sure a read is linearizable
+type ReadMode int
+
+const (
+	ReadModeIndex ReadMode = iota // ReadIndex: one heartbeat round, no log entry
+	ReadModeLog                   // replicate the read through the Raft log
+	ReadModeLease                 // answer locally while the leader lease holds
+)
+
+var readModeName = map[ReadMode]string{
+	ReadModeIndex: "readIndex",
+	ReadModeLog:   "log",
+	ReadModeLease: "lease",
+}
+
+func (rm ReadMode) String() string {
+	return readModeName[rm]
 }
 
 type GetResponse struct {
@@ -9,6 +9,8 @@ const (
 	Candidate
 	Leader
 	Dead
+	Learner
+	Faulted
 )
 
 func (s RfState) String() string {
@@ -21,6 +23,10 @@ func (s RfState) String() string {
 		return "Leader"
 	case Dead:
 		return "Dead"
+	case Learner:
+		return "Learner"
+	case Faulted:
+		return "Faulted"
 	default:
 		panic("unreachable")
 	}
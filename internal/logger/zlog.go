@@ -2,6 +2,8 @@ package logger
 
 import (
 	"container/ring"
+	"io"
+	"os"
 	"sync"
 	"time"
 
@@ -98,9 +100,15 @@ func min(a, b int) int {
 	return b
 }
 
+// Output is where zerolog events end up, the memory logger once SetupLogger
+// ran. Loggers built apart from log.Logger, e.g. one stamping a simulation's
+// events with virtual time, write there too.
+var Output io.Writer = os.Stderr
+
 func SetupLogger(memLogger *MemoryLogger) {
 	zerolog.TimeFieldFormat = time.RFC3339
-	log.Logger = zerolog.New(memLogger).With().Timestamp().Logger()
+	Output = memLogger
+	log.Logger = zerolog.New(Output).With().Timestamp().Logger()
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 }
@@ -0,0 +1,389 @@
+// CHANNEL TRANSPORT
+// Carries RPCs between nodes of one process over channels instead of
+// sockets, so a simulation needs no ports or connections per node and can
+// run clusters of a hundred nodes. Calls still go through each node's
+// RPCProxy, so they are dropped and delayed exactly as over TCP.
+package raft
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// ChannelNetwork links the ChannelTransports of one simulated cluster.
+type ChannelNetwork struct {
+	mu    sync.Mutex
+	seq   int
+	nodes map[channelAddr]*ChannelTransport // serving transports
+	queue *messageQueue                     // nil unless calls wait for Deliver
+}
+
+func NewChannelNetwork() *ChannelNetwork {
+	return &ChannelNetwork{nodes: make(map[channelAddr]*ChannelTransport)}
+}
+
+// NewQueuedChannelNetwork returns a network whose calls and replies wait in
+// a queue, due on clock, until Deliver hands them over; see messageQueue.
+// Nodes on it send without waiting for replies, so they must be TickDriven
+// and ticked from the goroutine that calls Deliver.
+func NewQueuedChannelNetwork(clock Clock) *ChannelNetwork {
+	n := NewChannelNetwork()
+	n.queue = &messageQueue{clock: clock}
+	return n
+}
+
+// Deliver hands over every call and reply due by now on the network's clock,
+// the earliest first and those due together in the order they were sent. It
+// does nothing on a network that isn't queued.
+func (n *ChannelNetwork) Deliver() {
+	if n.queue != nil {
+		n.queue.deliver()
+	}
+}
+
+// Transport returns a new transport for node id on this network; pass it as
+// Config.NewTransport.
+func (n *ChannelNetwork) Transport(id int) Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	return &ChannelTransport{
+		network: n,
+		addr:    channelAddr{id: id, seq: n.seq},
+		calls:   make(chan *channelCall),
+		quit:    make(chan any),
+		peers:   make(map[int]*channelConn),
+		logger:  &log.Logger,
+	}
+}
+
+// channelAddr names one transport; a restarted node gets a new one, so
+// connections to its previous incarnation don't reach it.
+type channelAddr struct {
+	id  int
+	seq int
+}
+
+func (a channelAddr) Network() string { return "chan" }
+func (a channelAddr) String() string  { return fmt.Sprintf("node-%d#%d", a.id, a.seq) }
+
+type channelCall struct {
+	method string
+	args   any
+	reply  any
+	done   chan error // buffered, the caller may be gone
+}
+
+// channelConn is a connection to a peer's transport; closing it fails the
+// calls waiting on it.
+type channelConn struct {
+	peer   *ChannelTransport
+	closed chan struct{}
+}
+
+// ChannelTransport is one node's end of a ChannelNetwork.
+type ChannelTransport struct {
+	mu      sync.Mutex
+	network *ChannelNetwork
+	addr    channelAddr
+	peers   map[int]*channelConn
+
+	handler Handler
+	calls   chan *channelCall
+	quit    chan any
+	closed  bool
+	wg      sync.WaitGroup
+
+	logger *zerolog.Logger
+}
+
+func (t *ChannelTransport) Serve(handler Handler) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.handler = handler
+	t.network.mu.Lock()
+	t.network.nodes[t.addr] = t
+	t.network.mu.Unlock()
+	t.logger.Info().
+		Int("raftID", t.addr.id).
+		Str("address", t.addr.String()).
+		Msg("serverListening")
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			select {
+			case <-t.quit:
+				return
+			case call := <-t.calls:
+				t.wg.Add(1)
+				go func() {
+					defer t.wg.Done()
+					call.done <- dispatch(handler, call.method, call.args, call.reply)
+				}()
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *ChannelTransport) Addr() net.Addr {
+	return t.addr
+}
+
+func (t *ChannelTransport) Connect(peerId int, addr net.Addr) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.peers[peerId] != nil {
+		return nil
+	}
+	a, ok := addr.(channelAddr)
+	t.network.mu.Lock()
+	peer := t.network.nodes[a]
+	t.network.mu.Unlock()
+	if !ok || peer == nil {
+		return fmt.Errorf("dial %s %s: %w", addr.Network(), addr, ErrPeerDisconnected)
+	}
+	t.peers[peerId] = &channelConn{peer: peer, closed: make(chan struct{})}
+	t.logger.Info().
+		Int("raftID", t.addr.id).
+		Int("peer", peerId).
+		Str("address", addr.String()).
+		Msg("peerConnected")
+	return nil
+}
+
+func (t *ChannelTransport) Disconnect(peerId int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if conn := t.peers[peerId]; conn != nil {
+		close(conn.closed)
+		delete(t.peers, peerId)
+		t.logger.Info().Int("peer", peerId).Msg("peerDisconnected")
+	}
+	return nil
+}
+
+func (t *ChannelTransport) DisconnectAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closePeers()
+	t.logger.Info().
+		Int("raftID", t.addr.id).
+		Msg("disconnectionComplete")
+}
+
+// closePeers expects t.mu to be locked.
+func (t *ChannelTransport) closePeers() {
+	for id, conn := range t.peers {
+		close(conn.closed)
+		delete(t.peers, id)
+	}
+}
+
+// Call hands the call to the peer's transport and waits for it to be
+// served. The peer gets a copy of args, as it would decoding them off a
+// socket, so the entries it stores never alias the caller's log. The handler
+// fills a reply of its own, copied to reply only on success, so a call given
+// up on never writes to the caller's reply late.
+func (t *ChannelTransport) Call(peerId int, method string, args any, reply any) error {
+	t.mu.Lock()
+	conn := t.peers[peerId]
+	t.mu.Unlock()
+	if conn == nil {
+		return peerDisconnected(peerId)
+	}
+
+	out := reflect.ValueOf(reply)
+	if out.Kind() != reflect.Pointer || out.IsNil() {
+		return fmt.Errorf("rpc: reply for %s is not a pointer", method)
+	}
+	call := &channelCall{
+		method: method,
+		args:   copyArgs(args),
+		reply:  reflect.New(out.Elem().Type()).Interface(),
+		done:   make(chan error, 1),
+	}
+
+	select {
+	case conn.peer.calls <- call:
+	case <-conn.peer.quit:
+		return peerDisconnected(peerId)
+	case <-conn.closed:
+		return peerDisconnected(peerId)
+	}
+	select {
+	case err := <-call.done:
+		if err != nil {
+			return err
+		}
+		out.Elem().Set(reflect.ValueOf(call.reply).Elem())
+		return nil
+	case <-conn.closed:
+		return peerDisconnected(peerId)
+	}
+}
+
+// holder is a Handler that may hold back the calls handed to it, as a
+// paused node does; a queued network then keeps them for the next Deliver.
+type holder interface {
+	holding() bool
+}
+
+// queued reports whether calls over t wait in its network's queue.
+func (t *ChannelTransport) queued() bool {
+	return t.network.queue != nil
+}
+
+// schedule queues deliver on t's network to run once delay has passed.
+func (t *ChannelTransport) schedule(delay time.Duration, deliver func() bool) {
+	t.network.queue.push(delay, deliver)
+}
+
+// post is Call over a queued network: it queues the call to peerId, due
+// after delay, and returns. When Deliver hands it over, the peer serves it
+// right away and done gets the result, with reply filled in on success. It
+// fails without queueing anything if t has no connection to peerId.
+func (t *ChannelTransport) post(peerId int, method string, args any, reply any, delay time.Duration, done func(error)) error {
+	t.mu.Lock()
+	conn := t.peers[peerId]
+	t.mu.Unlock()
+	if conn == nil {
+		return peerDisconnected(peerId)
+	}
+	out := reflect.ValueOf(reply)
+	if out.Kind() != reflect.Pointer || out.IsNil() {
+		return fmt.Errorf("rpc: reply for %s is not a pointer", method)
+	}
+	args = copyArgs(args)
+
+	t.schedule(delay, func() bool {
+		peer := conn.peer
+		peer.mu.Lock()
+		select {
+		case <-conn.closed:
+			peer.mu.Unlock()
+			done(peerDisconnected(peerId))
+			return true
+		default:
+		}
+		if peer.closed {
+			peer.mu.Unlock()
+			done(peerDisconnected(peerId))
+			return true
+		}
+		handler := peer.handler
+		peer.wg.Add(1)
+		peer.mu.Unlock()
+		defer peer.wg.Done()
+
+		if h, ok := handler.(holder); ok && h.holding() {
+			return false
+		}
+		served := reflect.New(out.Elem().Type()).Interface()
+		err := dispatch(handler, method, args, served)
+		if err == nil {
+			out.Elem().Set(reflect.ValueOf(served).Elem())
+		}
+		done(err)
+		return true
+	})
+	return nil
+}
+
+func (t *ChannelTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.quit)
+	t.closePeers()
+	t.mu.Unlock()
+
+	t.network.mu.Lock()
+	delete(t.network.nodes, t.addr)
+	t.network.mu.Unlock()
+
+	t.wg.Wait()
+	return nil
+}
+
+// copyArgs deep-copies the slices in args that the receiving node keeps.
+func copyArgs(args any) any {
+	switch a := args.(type) {
+	case AppendEntriesArgs:
+		if a.Entries != nil {
+			entries := make([]LogEntry, len(a.Entries))
+			for i, entry := range a.Entries {
+				if cfg, ok := entry.Command.(ConfigEntry); ok {
+					entry.Command = cfg.clone()
+				}
+				entries[i] = entry
+			}
+			a.Entries = entries
+		}
+		return a
+	case InstallSnapshotArgs:
+		a.LastIncludedConfig = a.LastIncludedConfig.clone()
+		a.Data = slices.Clone(a.Data)
+		return a
+	}
+	return args
+}
+
+// dispatch calls the handler method named by method, the way net/rpc would.
+func dispatch(h Handler, method string, args any, reply any) error {
+	var err error
+	ok := true
+	switch method {
+	case "Raft.RequestVote":
+		a, okArgs := args.(RequestVoteArgs)
+		r, okReply := reply.(*RequestVoteReply)
+		if ok = okArgs && okReply; ok {
+			err = h.RequestVote(a, r)
+		}
+	case "Raft.PreVote":
+		a, okArgs := args.(PreVoteArgs)
+		r, okReply := reply.(*PreVoteReply)
+		if ok = okArgs && okReply; ok {
+			err = h.PreVote(a, r)
+		}
+	case "Raft.AppendEntries":
+		a, okArgs := args.(AppendEntriesArgs)
+		r, okReply := reply.(*AppendEntriesReply)
+		if ok = okArgs && okReply; ok {
+			err = h.AppendEntries(a, r)
+		}
+	case "Raft.InstallSnapshot":
+		a, okArgs := args.(InstallSnapshotArgs)
+		r, okReply := reply.(*InstallSnapshotReply)
+		if ok = okArgs && okReply; ok {
+			err = h.InstallSnapshot(a, r)
+		}
+	case "Raft.TimeoutNow":
+		a, okArgs := args.(TimeoutNowArgs)
+		r, okReply := reply.(*TimeoutNowReply)
+		if ok = okArgs && okReply; ok {
+			err = h.TimeoutNow(a, r)
+		}
+	default:
+		return fmt.Errorf("rpc: can't find method %s", method)
+	}
+	if !ok {
+		return fmt.Errorf("rpc: wrong argument or reply type for %s", method)
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package raft
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// keepingHandler keeps the args of the calls it serves.
+type keepingHandler struct {
+	Handler
+	appended  AppendEntriesArgs
+	installed InstallSnapshotArgs
+}
+
+func (h *keepingHandler) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
+	h.appended = args
+	return nil
+}
+
+func (h *keepingHandler) InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	h.installed = args
+	return nil
+}
+
+func TestChannelTransportCopiesArgs(t *testing.T) {
+	nop := zerolog.Nop()
+	network := NewChannelNetwork()
+	sender := network.Transport(0).(*ChannelTransport)
+	receiver := network.Transport(1).(*ChannelTransport)
+	sender.logger, receiver.logger = &nop, &nop
+	defer sender.Close()
+	defer receiver.Close()
+
+	h := &keepingHandler{}
+	if err := receiver.Serve(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Connect(1, receiver.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	log := []LogEntry{
+		{Command: "a", Term: 1},
+		{Command: ConfigEntry{Voters: []int{0, 1}}, Term: 1},
+	}
+	if err := sender.Call(1, "Raft.AppendEntries", AppendEntriesArgs{Entries: log}, &AppendEntriesReply{}); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("snapshot")
+	snapshot := InstallSnapshotArgs{LastIncludedConfig: ConfigEntry{Voters: []int{0, 1}}, Data: data}
+	if err := sender.Call(1, "Raft.InstallSnapshot", snapshot, &InstallSnapshotReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sender goes on changing its log and snapshot in place.
+	log[0] = LogEntry{Command: "b", Term: 2}
+	log[1].Command.(ConfigEntry).Voters[1] = 2
+	data[0] = 'S'
+	snapshot.LastIncludedConfig.Voters[1] = 2
+
+	got := h.appended.Entries
+	if got[0].Command != "a" || got[0].Term != 1 {
+		t.Errorf("received entry 0 changed to %+v", got[0])
+	}
+	if voters := got[1].Command.(ConfigEntry).Voters; !slices.Equal(voters, []int{0, 1}) {
+		t.Errorf("received config entry changed to voters %v", voters)
+	}
+	if string(h.installed.Data) != "snapshot" {
+		t.Errorf("received snapshot changed to %q", h.installed.Data)
+	}
+	if voters := h.installed.LastIncludedConfig.Voters; !slices.Equal(voters, []int{0, 1}) {
+		t.Errorf("received snapshot config changed to voters %v", voters)
+	}
+}
@@ -1,115 +1,152 @@
 package raft
 
 import (
-	"math/rand"
 	"time"
-
-	"github.com/rs/zerolog/log"
 )
 
 func (rf *Raft) electionTimeout() time.Duration {
-	return time.Duration(150+rand.Intn(150)) * time.Millisecond
+	return rf.cfg.ElectionTimeoutMin + time.Duration(rf.rand.Int63n(int64(rf.cfg.ElectionTimeoutMax-rf.cfg.ElectionTimeoutMin)))
 }
 
-func (rf *Raft) runElectionTimer() {
-	timeoutDuration := rf.electionTimeout()
+// startElectionTimer arms a fresh election timer for the current term: a
+// goroutine normally, the single timer Tick checks for a TickDriven node.
+// Expects rf.mu to be locked.
+func (rf *Raft) startElectionTimer() {
+	if !rf.cfg.TickDriven {
+		go rf.runElectionTimer()
+		return
+	}
+	rf.timerRunning = true
+	rf.timerTerm = rf.currentTerm
+	rf.timerTimeout = rf.electionTimeout()
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.timerTerm).
+		Int("state", int(rf.state)).
+		Msg("electionTimerStarted")
+}
 
+func (rf *Raft) runElectionTimer() {
 	rf.mu.Lock()
+	timeoutDuration := rf.electionTimeout()
 	termStarted := rf.currentTerm
-	rf.mu.Unlock()
-
-	log.Info().
+	rf.logger.Info().
 		Int("raftID", rf.id).
 		Int("term", termStarted).
 		Int("state", int(rf.state)).
 		Msg("electionTimerStarted")
-
-	ticker := time.NewTicker(10 * time.Millisecond)
-	defer ticker.Stop()
+	rf.mu.Unlock()
 
 	for {
-		<-ticker.C
+		<-rf.clock.After(rf.cfg.TickInterval)
+		rf.waitResumed()
 
 		rf.mu.Lock()
-		if rf.state != Candidate && rf.state != Follower {
-			log.Info().
-				Int("raftID", rf.id).
-				Int("term", termStarted).
-				Int("state", int(rf.state)).
-				Msg("electionTimerStoppedI")
-			rf.mu.Unlock()
+		done := rf.electionTimerTick(termStarted, timeoutDuration)
+		rf.mu.Unlock()
+		if done {
 			return
 		}
+	}
+}
 
-		if termStarted != rf.currentTerm {
-			log.Info().
-				Int("raftID", rf.id).
-				Int("term", termStarted).
-				Int("state", int(rf.state)).
-				Msg("electionTimerStoppedII")
-			rf.mu.Unlock()
-			return
-		}
+// electionTimerTick runs one check of an election timer started in
+// termStarted and reports whether the timer is done, either because it is
+// no longer needed or because it fired. Expects rf.mu to be locked.
+func (rf *Raft) electionTimerTick(termStarted int, timeoutDuration time.Duration) bool {
+	if rf.state != Candidate && rf.state != Follower {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", termStarted).
+			Int("state", int(rf.state)).
+			Msg("electionTimerStoppedI")
+		return true
+	}
 
-		// If timeout occurs, start a new election
-		if time.Since(rf.electionResetEvent) >= timeoutDuration {
-			log.Info().
-				Int("raftID", rf.id).
-				Int("term", termStarted).
-				Int("state", int(rf.state)).
-				Msg("electionTimeout")
-			rf.startElection()
-			rf.mu.Unlock()
-			return
+	if termStarted != rf.currentTerm {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", termStarted).
+			Int("state", int(rf.state)).
+			Msg("electionTimerStoppedII")
+		return true
+	}
+
+	// Nodes outside the configuration never campaign.
+	if !rf.isVoter() {
+		rf.electionResetEvent = rf.clock.Now()
+	}
+
+	// If timeout occurs, start a new election
+	if rf.since(rf.electionResetEvent) >= timeoutDuration {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", termStarted).
+			Int("state", int(rf.state)).
+			Msg("electionTimeout")
+		if rf.preVote {
+			rf.startPreVote()
+		} else {
+			rf.startElection(false)
 		}
-		rf.mu.Unlock()
+		return true
 	}
+	return false
 }
 
-func (rf *Raft) startElection() {
+// startElection campaigns for the next term. transfer is set when the
+// current leader handed leadership to us via TimeoutNow.
+func (rf *Raft) startElection(transfer bool) {
 	rf.state = Candidate
 	rf.currentTerm++
 	savedCurrentTerm := rf.currentTerm
-	rf.electionResetEvent = time.Now()
+	rf.electionResetEvent = rf.clock.Now()
 	rf.votedFor = rf.id
-	log.Info().
+	rf.leaderId = -1
+	if err := rf.persist(rf.stageHardState); err != nil {
+		rf.fault(err)
+		return
+	}
+	rf.logger.Info().
 		Int("raftID", rf.id).
 		Str("oldState", Follower.String()).
 		Str("newState", rf.state.String()).
 		Msg("stateTransition")
-	votesReceived := 0
+	votesReceived := 1 // our own
 	repliesNeeded := len(rf.peerIds)
 
-	for _, peerId := range rf.peerIds {
-		go func(pid int) {
-			rf.mu.Lock()
-			savedLastLogIndex, savedLastLogTerm := rf.lastLogIndexAndTerm()
-			rf.mu.Unlock()
-
-			log.Info().
-				Int("raftID", rf.id).
-				Int("term", savedCurrentTerm).
-				Str("state", rf.state.String()).
-				Int("peer", pid).
-				Msg("requestVote")
-
-			args := RequestVoteArgs{
-				Term:         savedCurrentTerm,
-				CandidateId:  rf.id,
-				LastLogIndex: savedLastLogIndex,
-				LastLogTerm:  savedLastLogTerm,
-			}
+	// sole voter in the configuration, nobody else to ask.
+	if repliesNeeded == 0 {
+		rf.startLeader()
+		return
+	}
 
-			var reply RequestVoteReply
-			err := rf.server.Call(pid, "Raft.RequestVote", args, &reply)
+	savedLastLogIndex, savedLastLogTerm := rf.lastLogIndexAndTerm()
+	args := RequestVoteArgs{
+		Term:         savedCurrentTerm,
+		CandidateId:  rf.id,
+		LastLogIndex: savedLastLogIndex,
+		LastLogTerm:  savedLastLogTerm,
 
+		LeadershipTransfer: transfer,
+	}
+	for _, pid := range rf.peerIds {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", savedCurrentTerm).
+			Str("state", rf.state.String()).
+			Int("peer", pid).
+			Msg("requestVote")
+
+		reply := new(RequestVoteReply)
+		rf.server.send(pid, "Raft.RequestVote", args, reply, func(err error) {
 			rf.mu.Lock()
 			defer rf.mu.Unlock()
 
 			repliesNeeded--
 
 			if err == nil {
-				log.Info().
+				rf.logger.Info().
 					Int("raftID", rf.id).
 					Int("term", reply.Term).
 					Str("state", rf.state.String()).
@@ -117,11 +154,11 @@ func (rf *Raft) startElection() {
 					Int("peer", pid).
 					Msg("recieveVote")
 
-				if rf.state != Candidate {
+				if rf.state != Candidate || rf.currentTerm != savedCurrentTerm {
 					// log.Printf("[Election] Ignoring vote as node is no longer a candidate (state=%v)", rf.state)
 				} else {
 					if reply.Term > savedCurrentTerm {
-						log.Info().
+						rf.logger.Info().
 							Int("raftID", rf.id).
 							Int("term", savedCurrentTerm).
 							Str("state", rf.state.String()).
@@ -130,10 +167,21 @@ func (rf *Raft) startElection() {
 						rf.becomeFollower(reply.Term)
 					} else if reply.Term == savedCurrentTerm && reply.VoteGranted {
 						votesReceived++
+						// a majority is enough; peers that haven't answered
+						// yet may never do so.
+						if rf.quorum(votesReceived) {
+							rf.startLeader()
+							rf.logger.Info().
+								Int("raftID", rf.id).
+								Int("term", savedCurrentTerm).
+								Str("state", rf.state.String()).
+								Msg("electionWon")
+							return
+						}
 					}
 				}
 			} else {
-				log.Info().
+				rf.logger.Info().
 					Int("raftID", rf.id).
 					Int("term", savedCurrentTerm).
 					Str("state", rf.state.String()).
@@ -141,30 +189,16 @@ func (rf *Raft) startElection() {
 					Msg("voteFailure")
 			}
 
-			if repliesNeeded == 0 {
-				votesReceived++
-
-				if rf.state == Candidate {
-					if votesReceived*2 > len(rf.peerIds)+1 {
-						rf.startLeader()
-						log.Info().
-							Int("raftID", rf.id).
-							Int("term", savedCurrentTerm).
-							Str("state", rf.state.String()).
-							Msg("electionWon")
-						return
-					} else {
-						log.Info().
-							Int("raftID", rf.id).
-							Int("term", savedCurrentTerm).
-							Str("state", rf.state.String()).
-							Msg("electionLost")
-					}
-				}
-				go rf.runElectionTimer()
+			if repliesNeeded == 0 && rf.state == Candidate && rf.currentTerm == savedCurrentTerm {
+				rf.logger.Info().
+					Int("raftID", rf.id).
+					Int("term", savedCurrentTerm).
+					Str("state", rf.state.String()).
+					Msg("electionLost")
+				rf.startElectionTimer()
 			}
-		}(peerId)
+		})
 	}
 
-	go rf.runElectionTimer()
+	rf.startElectionTimer()
 }
@@ -0,0 +1,197 @@
+// CLOCK
+// Time and randomness as seen by a node. Every timer in the core goes through
+// a Clock, so a simulation can swap in a VirtualClock that only moves when
+// told to and run whole scenarios faster than real time.
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is the time source a node runs on.
+type Clock interface {
+	Now() time.Time
+	// After fires once the clock has moved d past the current time.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// VirtualClock is a Clock that stands still until Advance is called. It can
+// be shared by a whole cluster so every node sees the same virtual time.
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []virtualWaiter
+}
+
+type virtualWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewVirtualClock returns a VirtualClock reading start.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.until(c.now.Add(d))
+}
+
+// Until fires once the clock reads t or later, right away if it already
+// does.
+func (c *VirtualClock) Until(t time.Time) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.until(t)
+}
+
+// until expects c.mu to be locked.
+func (c *VirtualClock) until(t time.Time) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if !t.After(c.now) {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, virtualWaiter{deadline: t, ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d and fires every After whose deadline
+// has been reached, earliest first.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	pending := c.waiters[:0]
+	var due []virtualWaiter
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			due = append(due, w)
+		}
+	}
+	c.waiters = pending
+
+	for len(due) > 0 {
+		first := 0
+		for i := range due {
+			if due[i].deadline.Before(due[first].deadline) {
+				first = i
+			}
+		}
+		due[first].ch <- c.now
+		due = append(due[:first], due[first+1:]...)
+	}
+}
+
+// SkewedClock is a Clock running rate times as fast as the clock it wraps
+// and reading offset ahead of it, like a node whose oscillator drifts or
+// whose time got stepped. Every timeout the node measures on it stretches or
+// shrinks accordingly. The skew can change while the node runs.
+type SkewedClock struct {
+	base Clock
+
+	mu         sync.Mutex
+	rate       float64
+	offset     time.Duration
+	baseAnchor time.Time // base time when the rate last changed
+	anchor     time.Time // our time then, offset left out
+}
+
+// NewSkewedClock returns a clock reading exactly like base until SetSkew is
+// called; a nil base is the wall clock.
+func NewSkewedClock(base Clock) *SkewedClock {
+	if base == nil {
+		base = realClock{}
+	}
+	now := base.Now()
+	return &SkewedClock{base: base, rate: 1, baseAnchor: now, anchor: now}
+}
+
+// SetSkew makes the clock run rate times as fast as its base from now on and
+// read offset ahead of where it would have been; changing offset steps the
+// clock. Timers already running keep the rate they started with.
+func (c *SkewedClock) SetSkew(rate float64, offset time.Duration) error {
+	if rate <= 0 {
+		return fmt.Errorf("%w: clock rate must be positive, got %v", ErrInvalidConfig, rate)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.base.Now()
+	c.anchor = c.skewed(now)
+	c.baseAnchor = now
+	c.rate = rate
+	c.offset = offset
+	return nil
+}
+
+// skewed maps base time t to ours, offset left out. Expects c.mu to be
+// locked.
+func (c *SkewedClock) skewed(t time.Time) time.Time {
+	return c.anchor.Add(time.Duration(float64(t.Sub(c.baseAnchor)) * c.rate))
+}
+
+func (c *SkewedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skewed(c.base.Now()).Add(c.offset)
+}
+
+// After fires once d has passed on this clock, d/rate on the base clock.
+func (c *SkewedClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	rate := c.rate
+	c.mu.Unlock()
+	return c.base.After(time.Duration(float64(d) / rate))
+}
+
+// since is time.Since on the node's clock.
+func (rf *Raft) since(t time.Time) time.Duration {
+	return rf.clock.Now().Sub(t)
+}
+
+// lockedSource makes a seeded rand.Source safe to share between the
+// goroutines of one node.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// newRand returns the random source for node id: derived from seed when one
+// is set so runs repeat, from the wall clock otherwise.
+func newRand(seed int64, id int) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(&lockedSource{src: rand.NewSource(seed + int64(id))})
+}
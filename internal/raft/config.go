@@ -0,0 +1,201 @@
+// CONFIG
+// Every timing the Raft core and its RPC proxy rely on. The defaults match
+// the values the simulation was tuned with; Scale stretches all of them at
+// once so the cluster can be slowed down enough to follow by eye.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrInvalidConfig = errors.New("raft: invalid config")
+
+type Config struct {
+	// Election timeouts are drawn from [ElectionTimeoutMin, ElectionTimeoutMax).
+	// A node that heard from a leader within ElectionTimeoutMin won't help
+	// anyone start an election; a leader that heard from no majority within
+	// ElectionTimeoutMax steps down.
+	ElectionTimeoutMin time.Duration
+	ElectionTimeoutMax time.Duration
+
+	HeartbeatInterval time.Duration // how often a leader sends AppendEntries
+	TickInterval      time.Duration // how often the election timer checks for a timeout
+
+	// Simulated network latency added by the RPC proxy to every incoming
+	// call, drawn from [RPCDelayMin, RPCDelayMax]. With RAFT_UNRELIABLE_RPC
+	// set, some calls are instead held back for UnreliableRPCDelay.
+	RPCDelayMin        time.Duration
+	RPCDelayMax        time.Duration
+	UnreliableRPCDelay time.Duration
+
+	// Overrides replaces the non-zero timings above, and the Clock if set,
+	// for individual nodes, e.g. to give one node a much shorter election
+	// timeout or a clock that runs fast.
+	Overrides map[int]Config
+
+	// Clock is the time source; nil means the wall clock. Seed makes the
+	// node's randomness (election timeouts, RPC delays) repeatable; 0 seeds
+	// from the wall clock.
+	Clock Clock
+	Seed  int64
+
+	// TickDriven stops the node from running its own election timer and
+	// heartbeat loops; instead every call to Tick performs one round of both.
+	// Together with a VirtualClock and a Seed this lets a simulation step
+	// the cluster deterministically and faster than real time.
+	TickDriven bool
+
+	// NewTransport makes the transport node id reaches its peers over; nil
+	// means TCP with net/rpc.
+	NewTransport func(id int) Transport
+
+	// Links, when set, injects the faults configured on it into every call
+	// between nodes; see LinkFault.
+	Links *Links
+
+	// Observer, when set, is told of every node's elections and applied
+	// entries.
+	Observer Observer
+
+	// Logger is what nodes and their transports log events to; nil means
+	// the global log.Logger.
+	Logger *zerolog.Logger
+}
+
+// DefaultConfig returns the timings the cluster has always run with.
+func DefaultConfig() Config {
+	return Config{
+		ElectionTimeoutMin: 150 * time.Millisecond,
+		ElectionTimeoutMax: 300 * time.Millisecond,
+		HeartbeatInterval:  50 * time.Millisecond,
+		TickInterval:       10 * time.Millisecond,
+		RPCDelayMin:        1 * time.Millisecond,
+		RPCDelayMax:        5 * time.Millisecond,
+		UnreliableRPCDelay: 75 * time.Millisecond,
+	}
+}
+
+// Scale returns a copy of c with every duration, overrides included,
+// multiplied by factor. Scale(10) runs the cluster ten times slower.
+func (c Config) Scale(factor float64) Config {
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * factor)
+	}
+	scaled := Config{
+		ElectionTimeoutMin: scale(c.ElectionTimeoutMin),
+		ElectionTimeoutMax: scale(c.ElectionTimeoutMax),
+		HeartbeatInterval:  scale(c.HeartbeatInterval),
+		TickInterval:       scale(c.TickInterval),
+		RPCDelayMin:        scale(c.RPCDelayMin),
+		RPCDelayMax:        scale(c.RPCDelayMax),
+		UnreliableRPCDelay: scale(c.UnreliableRPCDelay),
+		Clock:              c.Clock,
+		Seed:               c.Seed,
+		TickDriven:         c.TickDriven,
+		NewTransport:       c.NewTransport,
+		Links:              c.Links,
+		Observer:           c.Observer,
+		Logger:             c.Logger,
+	}
+	if c.Overrides != nil {
+		scaled.Overrides = make(map[int]Config, len(c.Overrides))
+		for id, o := range c.Overrides {
+			scaled.Overrides[id] = o.Scale(factor)
+		}
+	}
+	return scaled
+}
+
+// ForNode returns the configuration node id runs with: c with that node's
+// override applied and no overrides left.
+func (c Config) ForNode(id int) Config {
+	o, ok := c.Overrides[id]
+	c.Overrides = nil
+	if !ok {
+		return c
+	}
+	override := func(d *time.Duration, v time.Duration) {
+		if v != 0 {
+			*d = v
+		}
+	}
+	override(&c.ElectionTimeoutMin, o.ElectionTimeoutMin)
+	override(&c.ElectionTimeoutMax, o.ElectionTimeoutMax)
+	override(&c.HeartbeatInterval, o.HeartbeatInterval)
+	override(&c.TickInterval, o.TickInterval)
+	override(&c.RPCDelayMin, o.RPCDelayMin)
+	override(&c.RPCDelayMax, o.RPCDelayMax)
+	override(&c.UnreliableRPCDelay, o.UnreliableRPCDelay)
+	if o.Clock != nil {
+		c.Clock = o.Clock
+	}
+	return c
+}
+
+// logger returns the logger events are logged to.
+func (c Config) logger() *zerolog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return &log.Logger
+}
+
+// MinElectionTimeout returns the shortest ElectionTimeoutMin any node runs
+// with, overrides included.
+func (c Config) MinElectionTimeout() time.Duration {
+	shortest := c.ElectionTimeoutMin
+	for id := range c.Overrides {
+		shortest = min(shortest, c.ForNode(id).ElectionTimeoutMin)
+	}
+	return shortest
+}
+
+// Validate checks that the timings can actually keep a leader in place:
+// heartbeats must arrive several times per election timeout, and the timer
+// must tick and the network deliver well within one heartbeat. Every
+// per-node override is checked as well.
+func (c Config) Validate() error {
+	if err := c.ForNode(-1).validate(); err != nil {
+		return err
+	}
+	for id := range c.Overrides {
+		if err := c.ForNode(id).validate(); err != nil {
+			return fmt.Errorf("node %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.ElectionTimeoutMin <= 0:
+		return fmt.Errorf("%w: ElectionTimeoutMin must be positive", ErrInvalidConfig)
+	case c.ElectionTimeoutMax <= c.ElectionTimeoutMin:
+		return fmt.Errorf("%w: ElectionTimeoutMax must be greater than ElectionTimeoutMin", ErrInvalidConfig)
+	case c.HeartbeatInterval <= 0:
+		return fmt.Errorf("%w: HeartbeatInterval must be positive", ErrInvalidConfig)
+	case c.HeartbeatInterval*3 > c.ElectionTimeoutMin:
+		return fmt.Errorf("%w: HeartbeatInterval %v must be at most a third of ElectionTimeoutMin %v",
+			ErrInvalidConfig, c.HeartbeatInterval, c.ElectionTimeoutMin)
+	case c.TickInterval <= 0 || c.TickInterval > c.HeartbeatInterval:
+		return fmt.Errorf("%w: TickInterval must be positive and at most HeartbeatInterval", ErrInvalidConfig)
+	case c.RPCDelayMin < 0 || c.RPCDelayMax < c.RPCDelayMin:
+		return fmt.Errorf("%w: RPC delays must satisfy 0 <= RPCDelayMin <= RPCDelayMax", ErrInvalidConfig)
+	case c.RPCDelayMax >= c.HeartbeatInterval:
+		return fmt.Errorf("%w: RPCDelayMax must be below HeartbeatInterval", ErrInvalidConfig)
+	case c.UnreliableRPCDelay < 0:
+		return fmt.Errorf("%w: UnreliableRPCDelay must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// rpcDelay draws a simulated network latency from [RPCDelayMin, RPCDelayMax].
+func (c Config) rpcDelay(r *rand.Rand) time.Duration {
+	return c.RPCDelayMin + time.Duration(r.Int63n(int64(c.RPCDelayMax-c.RPCDelayMin)+1))
+}
@@ -0,0 +1,16 @@
+package raft
+
+import "errors"
+
+// Errors shared by the client-facing Raft APIs.
+var (
+	ErrNotLeader         = errors.New("raft: not the leader")
+	ErrLeadershipLost    = errors.New("raft: leadership lost")
+	ErrTimeout           = errors.New("raft: timed out")
+	ErrStopped           = errors.New("raft: node stopped")
+	ErrReadIndexNotReady = errors.New("raft: leader has not committed an entry in its term yet")
+	ErrLeaseDisabled     = errors.New("raft: lease reads are disabled")
+	ErrLeaseExpired      = errors.New("raft: leader lease expired")
+	ErrStorageFault      = errors.New("raft: node faulted after a storage failure")
+	ErrPaused            = errors.New("raft: node is paused")
+)
@@ -0,0 +1,216 @@
+// FAULTY STORAGE
+// A Storage wrapper for simulations that misbehaves the way disks do: slow
+// writes, writes acknowledged before they are durable and lost when the
+// machine crashes, batches that land only in part, and failing reads. Raft
+// relies on its storage keeping every batch Commit acknowledged; breaking
+// that shows what goes wrong when votes and log entries aren't really
+// persisted before a node replies.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrInjectedRead is returned by the reads DiskFaults.ReadErrors fails.
+var ErrInjectedRead = errors.New("raft: injected read error")
+
+// DiskFaults describes how a FaultyStorage misbehaves. The zero value passes
+// everything through to the wrapped storage.
+type DiskFaults struct {
+	// WriteLatency delays every Commit, like a slow fsync.
+	WriteLatency time.Duration
+
+	// Unsynced is how many of the latest batches wait in a write cache
+	// instead of reaching the wrapped storage. Commit acknowledges them all
+	// the same; Crash can lose them.
+	Unsynced int
+
+	// TornWrites makes Crash write the first half of the oldest batch it
+	// loses, instead of none of it.
+	TornWrites bool
+
+	// ReadErrors lists the reads, counted from 1 across Get and Entries
+	// since the storage was opened, that fail with ErrInjectedRead.
+	ReadErrors []int
+}
+
+// FaultyStorage wraps a Storage and injects DiskFaults into it.
+type FaultyStorage struct {
+	mu     sync.Mutex
+	inner  Storage
+	faults DiskFaults
+	cache  []*Batch // acknowledged but not written to inner yet, oldest first
+	reads  int
+}
+
+// NewFaultyStorage wraps inner, healthy until SetFaults says otherwise.
+func NewFaultyStorage(inner Storage) *FaultyStorage {
+	return &FaultyStorage{inner: inner}
+}
+
+// SetFaults makes the storage misbehave as f from now on. Batches cached
+// beyond a lower f.Unsynced are written out with the next Commit.
+func (s *FaultyStorage) SetFaults(f DiskFaults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+	s.faults.ReadErrors = slices.Clone(f.ReadErrors)
+}
+
+func (s *FaultyStorage) Commit(b *Batch) error {
+	s.mu.Lock()
+	latency := s.faults.WriteLatency
+	s.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = append(s.cache, b)
+	return s.flush(s.faults.Unsynced)
+}
+
+// flush writes cached batches to the wrapped storage until at most keep are
+// left. A batch the wrapped storage fails is dropped, not retried. Expects
+// s.mu to be locked.
+func (s *FaultyStorage) flush(keep int) error {
+	for len(s.cache) > keep {
+		b := s.cache[0]
+		s.cache = s.cache[1:]
+		if err := s.inner.Commit(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read counts a read and fails it if it is scheduled to. Expects s.mu to be
+// locked.
+func (s *FaultyStorage) read() error {
+	s.reads++
+	if slices.Contains(s.faults.ReadErrors, s.reads) {
+		return fmt.Errorf("read %d: %w", s.reads, ErrInjectedRead)
+	}
+	return nil
+}
+
+// Get sees every acknowledged batch, the cached ones laid over the wrapped
+// storage like a page cache. Reading never writes the cache out: only Commit,
+// Close and Crash do, so a Crash still loses what a read has seen.
+func (s *FaultyStorage) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.read(); err != nil {
+		return nil, false, err
+	}
+	for i := len(s.cache) - 1; i >= 0; i-- {
+		ops := s.cache[i].ops
+		for j := len(ops) - 1; j >= 0; j-- {
+			if ops[j].Kind == BatchSet && ops[j].Key == key {
+				return ops[j].Value, true, nil
+			}
+		}
+	}
+	return s.inner.Get(key)
+}
+
+// Entries are the wrapped storage's entries with the cached batches applied
+// over them, as for Get.
+func (s *FaultyStorage) Entries() (int, [][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.read(); err != nil {
+		return 0, nil, err
+	}
+	first, entries, err := s.inner.Entries()
+	if err != nil || len(s.cache) == 0 {
+		return first, entries, err
+	}
+	overlay := &MapStorage{m: make(map[string][]byte), firstIndex: first, entries: entries}
+	for _, b := range s.cache {
+		if err := overlay.Commit(b); err != nil {
+			return 0, nil, err
+		}
+	}
+	return overlay.Entries()
+}
+
+func (s *FaultyStorage) HasData() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cache) > 0 || s.inner.HasData()
+}
+
+// Crash loses power: the newest lose batches still in the write cache never
+// reach the wrapped storage, while the ones before them do. With TornWrites
+// the first half of the oldest lost batch lands anyway. It returns how many
+// batches were lost and whether one of them was torn.
+func (s *FaultyStorage) Crash(lose int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lose = min(max(lose, 0), len(s.cache))
+	lost := s.cache[len(s.cache)-lose:]
+	s.cache = s.cache[:len(s.cache)-lose]
+	if err := s.flush(0); err != nil {
+		return lose, false, err
+	}
+	if lose == 0 || !s.faults.TornWrites {
+		return lose, false, nil
+	}
+	torn := tornPrefix(lost[0])
+	if torn.Empty() {
+		return lose, false, nil
+	}
+	return lose, true, s.inner.Commit(torn)
+}
+
+// tornPrefix returns the first half of b's writes, counting every entry an
+// append stores as a write of its own.
+func tornPrefix(b *Batch) *Batch {
+	total := 0
+	for _, op := range b.ops {
+		total += opWrites(op)
+	}
+	left := total / 2
+	torn := &Batch{}
+	for _, op := range b.ops {
+		if left == 0 {
+			break
+		}
+		if n := opWrites(op); n > left {
+			// only part of the entries made it.
+			op.Entries = op.Entries[:left]
+		}
+		left -= opWrites(op)
+		torn.ops = append(torn.ops, op)
+	}
+	return torn
+}
+
+func opWrites(op BatchOp) int {
+	if op.Kind == BatchAppend {
+		// appending nothing still truncates.
+		return max(len(op.Entries), 1)
+	}
+	return 1
+}
+
+// Close writes out the cache, as a clean shutdown would, and closes the
+// wrapped storage if it holds files.
+func (s *FaultyStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush(0)
+	if closer, ok := s.inner.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
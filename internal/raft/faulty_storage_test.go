@@ -0,0 +1,44 @@
+package raft
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFaultyStorageReadsDoNotSync(t *testing.T) {
+	inner := NewMapStorage()
+	s := NewFaultyStorage(inner)
+	s.SetFaults(DiskFaults{Unsynced: 2})
+	for i, term := range []string{"1", "2", "3"} {
+		var b Batch
+		b.Set("term", []byte(term))
+		b.AppendEntries(i, [][]byte{[]byte(term)})
+		if i == 2 {
+			b.CompactEntries(1)
+		}
+		if err := s.Commit(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// reads see all three batches, two of them from the cache.
+	v, found, err := s.Get("term")
+	if err != nil || !found || string(v) != "3" {
+		t.Errorf("term = %q (found %v, %v), want 3", v, found, err)
+	}
+	first, entries, err := s.Entries()
+	if err != nil || first != 1 || !slices.EqualFunc(entries, [][]byte{[]byte("2"), []byte("3")}, slices.Equal) {
+		t.Errorf("entries from %d: %q (%v), want from 1: [2 3]", first, entries, err)
+	}
+
+	// and wrote none of them out: a crash loses both cached batches.
+	if lost, _, err := s.Crash(2); err != nil || lost != 2 {
+		t.Fatalf("crash lost %d batches (%v), want 2", lost, err)
+	}
+	if v, _, _ := inner.Get("term"); string(v) != "1" {
+		t.Errorf("term = %q after the crash, want 1", v)
+	}
+	if first, entries, _ := inner.Entries(); first != 0 || len(entries) != 1 {
+		t.Errorf("entries from %d: %q after the crash, want from 0: [1]", first, entries)
+	}
+}
@@ -2,9 +2,7 @@
 // Appending Entries
 package raft
 
-import (
-	"time"
-)
+import "slices"
 
 type AppendEntriesArgs struct {
 	Term     int
@@ -33,10 +31,13 @@ func (rf *Raft) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply)
 	if rf.state == Dead {
 		return nil
 	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
 
 	if args.Term > rf.currentTerm {
 		rf.becomeFollower(args.Term)
-		rf.electionResetEvent = time.Now()
+		rf.electionResetEvent = rf.clock.Now()
 	}
 
 	reply.Term = rf.currentTerm
@@ -44,30 +45,29 @@ func (rf *Raft) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply)
 
 	// check if leader regime is ON.
 	if args.Term == rf.currentTerm {
-		if rf.state != Follower {
+		if rf.state != Follower && rf.state != Learner {
 			rf.becomeFollower(args.Term)
 		}
-		rf.electionResetEvent = time.Now()
+		rf.electionResetEvent = rf.clock.Now()
+		rf.lastLeaderContact = rf.electionResetEvent
+		rf.leaderId = args.LeaderId
 
-		// leader fresh af.
-		if args.PrevLogIndex == -1 {
-			if len(rf.log) == 0 {
-				reply.Success = true
-			} else {
-				reply.ConflictIndex = 0
-				reply.ConflictTerm = rf.log[0].Term
-			} // check if the follower logs are synced.
-		} else if args.PrevLogIndex < len(rf.log) && args.PrevLogTerm == rf.log[args.PrevLogIndex].Term {
+		if args.PrevLogIndex < rf.snapshotIndex {
+			// prefix already compacted here; resend from past the snapshot.
+			reply.ConflictIndex = rf.snapshotIndex + 1
+			reply.ConflictTerm = -1
+		} else if args.PrevLogIndex < rf.logLen() && args.PrevLogTerm == rf.termAt(args.PrevLogIndex) {
+			// follower logs are synced (PrevLogIndex == snapshotIndex always matches).
 			reply.Success = true
 			logInsertIndex := args.PrevLogIndex + 1 // follower
 			newEntriesIndex := 0                    // leader
 
 			// find the entry offset via pattern(term) matching .
 			for {
-				if logInsertIndex >= len(rf.log) || newEntriesIndex >= len(args.Entries) {
+				if logInsertIndex >= rf.logLen() || newEntriesIndex >= len(args.Entries) {
 					break
 				}
-				if rf.log[logInsertIndex].Term != args.Entries[newEntriesIndex].Term {
+				if rf.termAt(logInsertIndex) != args.Entries[newEntriesIndex].Term {
 					break
 				}
 				logInsertIndex++
@@ -75,164 +75,235 @@ func (rf *Raft) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply)
 			}
 
 			if newEntriesIndex < len(args.Entries) {
-				rf.log = append(rf.log[:logInsertIndex], args.Entries[newEntriesIndex:]...)
+				rf.log = append(rf.log[:rf.logPos(logInsertIndex)], args.Entries[newEntriesIndex:]...)
+				if err := rf.persist(rf.stageEntries(logInsertIndex)); err != nil {
+					rf.fault(err)
+					return ErrStorageFault
+				}
+				rf.applyConfig()
 			}
 
 			if args.LeaderCommit > rf.commitIndex {
-				rf.commitIndex = min(args.LeaderCommit, len(rf.log)-1)
-				rf.newCommitReadyChan <- struct{}{}
-			}
-		}
-	} else { // collison detection
-		if args.PrevLogIndex >= len(rf.log) {
-			reply.ConflictIndex = len(rf.log)
-			reply.ConflictTerm = -1
-		} else {
-			if args.PrevLogIndex < 0 || args.PrevLogIndex >= len(rf.log) {
-				reply.Success = false
-				reply.ConflictIndex = len(rf.log)
-				return nil
+				rf.commitIndex = min(args.LeaderCommit, rf.logLen()-1)
+				rf.commitReady()
 			}
-			conflictTerm := rf.log[args.PrevLogIndex].Term
-			reply.ConflictTerm = conflictTerm
-			i := args.PrevLogIndex - 1
-			for i >= 0 && rf.log[i].Term == conflictTerm {
-				i--
+		} else { // collison detection
+			if args.PrevLogIndex >= rf.logLen() {
+				reply.ConflictIndex = rf.logLen()
+				reply.ConflictTerm = -1
+			} else {
+				conflictTerm := rf.termAt(args.PrevLogIndex)
+				reply.ConflictTerm = conflictTerm
+				i := args.PrevLogIndex - 1
+				for i > rf.snapshotIndex && rf.termAt(i) == conflictTerm {
+					i--
+				}
+				reply.ConflictIndex = i + 1
 			}
-			reply.ConflictIndex = i + 1
 		}
 	}
 
-	rf.persistToStorage()
+	if err := rf.persist(rf.stageHardState); err != nil {
+		rf.fault(err)
+	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
 	return nil
 }
 
 func (rf *Raft) leaderSendHeartbeats() {
 	rf.mu.Lock()
+	defer rf.mu.Unlock()
 	if rf.state != Leader {
-		rf.mu.Unlock()
 		return
 	}
 	savedCurrentTerm := rf.currentTerm
-	rf.mu.Unlock()
+	rf.heartbeatRound++
+	round := rf.heartbeatRound
 
-	for _, peerId := range rf.peerIds {
-		go func(peerId int) {
-			rf.mu.Lock()
-			nextIndexForPeer := rf.nextIndex[peerId]
-			prevLogIndex := nextIndexForPeer - 1
-			prevLogTerm := -1
-			if prevLogIndex >= 0 {
-				prevLogTerm = rf.log[prevLogIndex].Term
+	for _, peerId := range rf.replicaIds() {
+		nextIndexForPeer := rf.nextIndex[peerId]
+		if nextIndexForPeer <= rf.snapshotIndex {
+			// the entries this peer needs are compacted away.
+			rf.leaderSendSnapshot(peerId, savedCurrentTerm, round)
+			continue
+		}
+		prevLogIndex := nextIndexForPeer - 1
+		prevLogTerm := rf.termAt(prevLogIndex)
+		entries := rf.log[rf.logPos(nextIndexForPeer):]
+
+		args := AppendEntriesArgs{
+			Term:         savedCurrentTerm,
+			LeaderId:     rf.id,
+			PrevLogIndex: prevLogIndex,
+			PrevLogTerm:  prevLogTerm,
+			Entries:      entries,
+			LeaderCommit: rf.commitIndex,
+		}
+
+		sentAt := rf.clock.Now()
+		reply := new(AppendEntriesReply)
+		rf.server.send(peerId, "Raft.AppendEntries", args, reply, func(err error) {
+			if err != nil {
+				return
 			}
-			entries := rf.log[nextIndexForPeer:]
-
-			args := AppendEntriesArgs{
-				Term:         savedCurrentTerm,
-				LeaderId:     rf.id,
-				PrevLogIndex: prevLogIndex,
-				PrevLogTerm:  prevLogTerm,
-				Entries:      entries,
-				LeaderCommit: rf.commitIndex,
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+
+			rf.lastContact[peerId] = rf.clock.Now()
+
+			if reply.Term > rf.currentTerm {
+				rf.becomeFollower(reply.Term)
+				return
 			}
-			rf.mu.Unlock()
 
-			var reply AppendEntriesReply
-			if err := rf.server.Call(peerId, "Raft.AppendEntries", args, &reply); err == nil {
-				rf.mu.Lock()
-				defer rf.mu.Unlock()
+			// any same-term reply, even a rejection, means peerId still follows us.
+			if rf.state == Leader && savedCurrentTerm == reply.Term {
+				rf.recordAck(peerId, round, sentAt)
+			}
 
-				if reply.Term > rf.currentTerm {
-					rf.becomeFollower(reply.Term)
-					return
-				}
+			if rf.state == Leader && savedCurrentTerm == reply.Term {
+				if reply.Success {
+					rf.nextIndex[peerId] = nextIndexForPeer + len(entries)
+					rf.matchIndex[peerId] = rf.nextIndex[peerId] - 1
 
-				if rf.state == Leader && savedCurrentTerm == reply.Term {
-					if reply.Success {
-						rf.nextIndex[peerId] = nextIndexForPeer + len(entries)
-						rf.matchIndex[peerId] = rf.nextIndex[peerId] - 1
-
-						// updating the commitIndex of leader
-						oldCommitIndex := rf.commitIndex
-						for i := rf.commitIndex + 1; i < len(rf.log); i++ {
-							if rf.log[i].Term == rf.currentTerm {
-								matchCount := 1
-								for _, pid := range rf.peerIds {
-									if rf.matchIndex[pid] >= i {
-										matchCount++
-									}
-								}
-								if matchCount*2 > len(rf.peerIds)+1 {
-									rf.commitIndex = i
-								}
+					rf.advanceCommitIndex()
+				} else {
+					// conflict resolution
+					if reply.ConflictTerm >= 0 {
+						lastIndexOfTerm := -1
+						for i := rf.logLen() - 1; i > rf.snapshotIndex; i-- {
+							if rf.termAt(i) == reply.ConflictTerm {
+								lastIndexOfTerm = i
+								break
 							}
 						}
-						if rf.commitIndex != oldCommitIndex {
-							rf.newCommitReadyChan <- struct{}{}
-							rf.triggerAEChan <- struct{}{}
-						}
-					} else {
-						// conflict resolution
-						if reply.ConflictTerm >= 0 {
-							lastIndexOfTerm := -1
-							for i := len(rf.log) - 1; i >= 0; i-- {
-								if rf.log[i].Term == reply.ConflictTerm {
-									lastIndexOfTerm = i
-									break
-								}
-							}
-							if lastIndexOfTerm >= 0 {
-								rf.nextIndex[peerId] = lastIndexOfTerm + 1
-							} else {
-								rf.nextIndex[peerId] = reply.ConflictIndex
-							}
+						if lastIndexOfTerm >= 0 {
+							rf.nextIndex[peerId] = lastIndexOfTerm + 1
 						} else {
 							rf.nextIndex[peerId] = reply.ConflictIndex
 						}
+					} else {
+						rf.nextIndex[peerId] = reply.ConflictIndex
 					}
 				}
 			}
-		}(peerId)
+		})
+	}
+}
+
+// advanceCommitIndex moves the leader's commitIndex up to the last entry of
+// its term a majority of the voters hold. It runs whenever an entry may have
+// reached a majority: on a follower's ack, and right after the leader
+// appends, which is all it takes when the leader is the only voter.
+// Expects rf.mu to be locked.
+func (rf *Raft) advanceCommitIndex() {
+	var matched []int
+	if rf.isVoter() {
+		matched = append(matched, rf.logLen()-1)
+	}
+	for _, pid := range rf.peerIds {
+		matched = append(matched, rf.matchIndex[pid])
+	}
+	quorumSize := len(rf.config)/2 + 1
+	if len(matched) < quorumSize {
+		return
+	}
+
+	// highest first; the quorumSize-th entry is held by a majority. Only an
+	// entry of the leader's own term commits by being counted, earlier ones
+	// along with it (figure 8 of the paper), and terms never go down the
+	// log, so checking the one entry is enough.
+	slices.SortFunc(matched, func(a, b int) int { return b - a })
+	index := matched[quorumSize-1]
+	if index <= rf.commitIndex || rf.termAt(index) != rf.currentTerm {
+		return
+	}
+	rf.commitIndex = index
+	rf.commitReady()
+	rf.triggerAE()
+
+	// a leader that removed itself steps down once that's committed.
+	if !rf.isVoter() && rf.commitIndex >= rf.configIndex {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", rf.currentTerm).
+			Msg("leaderRemoved")
+		rf.becomeFollowerWithReason(rf.currentTerm, "removedFromConfig")
 	}
 }
 
+// hasQuorumContact reports whether a majority of the voters (counting this
+// node) answered an RPC within the longest election timeout. A leader that
+// can't say so may already have been replaced and should step down.
+// Expects rf.mu to be locked.
+func (rf *Raft) hasQuorumContact() bool {
+	contacts := 0
+	if rf.isVoter() {
+		contacts++
+	}
+	for _, peerId := range rf.peerIds {
+		if rf.since(rf.lastContact[peerId]) < rf.cfg.ElectionTimeoutMax {
+			contacts++
+		}
+	}
+	return rf.quorum(contacts)
+}
+
 // commitChanSender sends committed entries on rf.commitChan by monitoring
 // newCommitReadyChan for newly ready entries. It runs in a background goroutine,
 // and rf.commitChan may be buffered to control the consumption speed.
 // It exits when newCommitReadyChan is closed.
 func (rf *Raft) commitChanSender() {
+	defer close(rf.commitSenderDone)
 	for range rf.newCommitReadyChan {
+		rf.waitResumed()
 		// Gather all entries to apply
 		rf.mu.Lock()
-		savedTerm := rf.currentTerm
+		var snapshotEntry *CommitEntry
+
+		// A pending snapshot always goes out before the entries following it.
+		if rf.snapshotPending {
+			snapshotEntry = &CommitEntry{
+				Index:    rf.snapshotIndex,
+				Term:     rf.snapshotTerm,
+				Snapshot: rf.snapshot,
+			}
+			rf.lastApplied = rf.snapshotIndex
+			rf.snapshotPending = false
+		}
+
 		savedLastApplied := rf.lastApplied
 		var readyEntries []LogEntry
 
 		if rf.commitIndex > rf.lastApplied {
-			readyEntries = rf.log[rf.lastApplied+1 : rf.commitIndex+1]
+			readyEntries = rf.log[rf.logPos(rf.lastApplied+1):rf.logPos(rf.commitIndex+1)]
 			rf.lastApplied = rf.commitIndex
 		}
 		rf.mu.Unlock()
 
+		if snapshotEntry != nil {
+			rf.deliver(*snapshotEntry)
+		}
+
 		// Send each newly committed entry on commitChan
 		for i, entry := range readyEntries {
 			commitIndex := savedLastApplied + i + 1
-			rf.commitChan <- CommitEntry{
+			if _, ok := entry.Command.(NoOpEntry); ok {
+				rf.deliver(CommitEntry{
+					Index:        commitIndex,
+					Term:         entry.Term,
+					LeaderChange: true,
+				})
+				continue
+			}
+			rf.deliver(CommitEntry{
 				Command: entry.Command,
 				Index:   commitIndex,
-				Term:    savedTerm,
-			}
-			//rf.dlog("CommitEntryDelivered", map[string]interface{}{
-			// 	"commitIndex": commitIndex,
-			// 	"term":        savedTerm,
-			// 	"command":     entry.Command,
-			// })
+				Term:    entry.Term,
+			})
+			rf.proposalApplied(commitIndex, entry.Term)
 		}
 	}
-
-	// When newCommitReadyChan is closed, this goroutine ends
-	//rf.dlog("CommitChanSenderStopped", map[string]interface{}{
-	// 	"reason": "newCommitReadyChan closed",
-	// 	"raftID": rf.id,
-	// })
 }
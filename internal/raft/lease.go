@@ -0,0 +1,71 @@
+// LEASE
+// Leader lease reads (section 6.4.1 of the Raft thesis). Followers refuse
+// votes for ElectionTimeoutMin after hearing from the leader, so once a
+// majority acked a heartbeat sent at time t nobody else can become leader
+// before t+ElectionTimeoutMin. Within that window, shortened by the assumed
+// clock drift, the leader answers reads from its own state machine.
+// Followers may run with shorter timeouts than the leader (Config.Overrides),
+// so the lease is measured from the shortest one in the cluster. Their
+// clocks may also run faster than the leader's; the lease only accounts for
+// that up to maxClockDrift, and a clock skewed further can break it.
+package raft
+
+import (
+	"slices"
+	"time"
+)
+
+// SetLeaseRead enables or disables lease reads. maxClockDrift is the bound on
+// how much faster or slower any node's clock may run, e.g. 0.1 for 10%.
+func (rf *Raft) SetLeaseRead(enabled bool, maxClockDrift float64) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.leaseRead = enabled
+	rf.maxClockDrift = maxClockDrift
+}
+
+// LeaseRead is ReadIndex without the heartbeat round: it returns commitIndex
+// right away while the leader lease holds, and ErrLeaseExpired otherwise so
+// the caller can fall back to ReadIndex.
+func (rf *Raft) LeaseRead() (int, error) {
+	rf.waitResumed()
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state != Leader {
+		return -1, ErrNotLeader
+	}
+	if !rf.leaseRead {
+		return -1, ErrLeaseDisabled
+	}
+	if rf.commitIndex < 0 || rf.termAt(rf.commitIndex) != rf.currentTerm {
+		return -1, ErrReadIndexNotReady
+	}
+	// a leader handing off leadership can't vouch for the lease anymore.
+	if rf.transferTarget >= 0 || !rf.hasQuorumContact() || rf.clock.Now().After(rf.leaseExpiry()) {
+		return -1, ErrLeaseExpired
+	}
+	return rf.commitIndex, nil
+}
+
+// leaseExpiry is when the current lease runs out: the send time of the most
+// recent heartbeat a majority acked, plus the drift-adjusted lease length.
+// Expects rf.mu to be locked.
+func (rf *Raft) leaseExpiry() time.Time {
+	var sentAt []time.Time
+	if rf.isVoter() {
+		sentAt = append(sentAt, rf.clock.Now())
+	}
+	for _, peerId := range rf.peerIds {
+		sentAt = append(sentAt, rf.ackSentAt[peerId])
+	}
+	quorumSize := len(rf.config)/2 + 1
+	if len(sentAt) < quorumSize {
+		return time.Time{}
+	}
+
+	// newest first; the quorumSize-th entry is acked by a majority.
+	slices.SortFunc(sentAt, func(a, b time.Time) int { return b.Compare(a) })
+	lease := time.Duration(float64(rf.leaseTimeout) * (1 - rf.maxClockDrift))
+	return sentAt[quorumSize-1].Add(lease)
+}
@@ -0,0 +1,81 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeaseRead(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	if _, err := leader.LeaseRead(); !errors.Is(err, ErrLeaseDisabled) {
+		t.Errorf("LeaseRead with leases off: got %v, want ErrLeaseDisabled", err)
+	}
+	for _, s := range c.servers {
+		s.SetLeaseRead(true, 0.1)
+	}
+	// one heartbeat round acked by a majority grants the lease.
+	c.run(c.cfg.HeartbeatInterval + c.cfg.TickInterval)
+
+	index, err := leader.LeaseRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := leader.Inspect().CommitIndex; index != want {
+		t.Errorf("lease read at %d, want the commit index %d", index, want)
+	}
+	if _, err := followerOf(c, leader).LeaseRead(); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("LeaseRead on a follower: got %v, want ErrNotLeader", err)
+	}
+}
+
+func TestLeaseExpiresBeforeAnotherLeaderCanServe(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	for _, s := range c.servers {
+		s.SetLeaseRead(true, 0.1)
+	}
+	leader := c.settledLeader(t)
+	c.run(c.cfg.HeartbeatInterval + c.cfg.TickInterval)
+	if _, err := leader.LeaseRead(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.isolate(leader)
+	isolated := c.clock.Now()
+	var expired time.Time
+	c.runUntil(t, "the lease to expire", func() bool {
+		if _, err := leader.LeaseRead(); err != nil {
+			if !errors.Is(err, ErrLeaseExpired) {
+				t.Fatalf("lease ended with %v, want ErrLeaseExpired", err)
+			}
+			expired = c.clock.Now()
+			return true
+		}
+		return false
+	})
+	// followers refuse votes for ElectionTimeoutMin after the last heartbeat
+	// they acked, so the lease has to end sooner than that.
+	if held := expired.Sub(isolated); held >= c.cfg.ElectionTimeoutMin {
+		t.Errorf("lease held for %v after isolation, want under %v", held, c.cfg.ElectionTimeoutMin)
+	}
+	if !leader.IsLeader() {
+		t.Error("leader stepped down before its lease expired")
+	}
+}
+
+func TestLeaseReadRefusedDuringTransfer(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	for _, s := range c.servers {
+		s.SetLeaseRead(true, 0.1)
+	}
+	leader := c.settledLeader(t)
+	c.run(c.cfg.HeartbeatInterval + c.cfg.TickInterval)
+
+	leader.rf.mu.Lock()
+	leader.rf.transferTarget = followerOf(c, leader).serverId
+	leader.rf.mu.Unlock()
+	if _, err := leader.LeaseRead(); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("LeaseRead while handing off leadership: got %v, want ErrLeaseExpired", err)
+	}
+}
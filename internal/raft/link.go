@@ -0,0 +1,218 @@
+// LINK FAULTS
+// A fault model for every directed link between two nodes, applied by the
+// RPC proxy of the calling node: a call travels the link from caller to
+// callee and its reply the link back, so cutting one direction lets requests
+// through but loses their replies. Faults can change while the cluster runs.
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrLinkDropped is returned for calls whose request or reply a link fault
+// dropped.
+var ErrLinkDropped = errors.New("raft: message dropped by link fault")
+
+// LinkFault describes how a directed link misbehaves. The zero value is a
+// healthy link. A link can also be cut outright with Links.Cut.
+type LinkFault struct {
+	// Drop is the probability a message on the link is lost.
+	Drop float64
+
+	// Every message is delayed by Latency plus up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Bandwidth caps the link at this many bytes per second; messages queue
+	// behind each other to get through. 0 means unlimited.
+	Bandwidth int
+
+	// Duplicate is the probability a request is delivered twice.
+	Duplicate float64
+
+	// Reorder is the probability a message is held back by up to
+	// ReorderWindow on top of its delay, so messages sent after it overtake it.
+	Reorder       float64
+	ReorderWindow time.Duration
+}
+
+type link struct {
+	from, to int
+}
+
+// Links holds the faults of every directed link in a cluster. One Links is
+// shared by all nodes through Config.Links.
+type Links struct {
+	mu     sync.Mutex
+	rand   *rand.Rand
+	faults map[link]LinkFault
+	cuts   map[link]bool      // links carrying nothing, kept apart from faults
+	busy   map[link]time.Time // when a bandwidth-capped link is free again
+}
+
+// NewLinks returns healthy links whose faults draw their randomness from
+// seed; 0 seeds from the wall clock.
+func NewLinks(seed int64) *Links {
+	return &Links{
+		rand:   newRand(seed, -1),
+		faults: make(map[link]LinkFault),
+		cuts:   make(map[link]bool),
+		busy:   make(map[link]time.Time),
+	}
+}
+
+// Set makes the link from -> to misbehave as f.
+func (l *Links) Set(from, to int, f LinkFault) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f == (LinkFault{}) {
+		delete(l.faults, link{from, to})
+		return
+	}
+	l.faults[link{from, to}] = f
+}
+
+// Get returns the fault on the link from -> to.
+func (l *Links) Get(from, to int) LinkFault {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.faults[link{from, to}]
+}
+
+// Reset clears the faults on every link. Cuts stay in place.
+func (l *Links) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.faults = make(map[link]LinkFault)
+	l.busy = make(map[link]time.Time)
+}
+
+// Cut stops the link from -> to from carrying anything, whatever its fault.
+func (l *Links) Cut(from, to int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cuts[link{from, to}] = true
+}
+
+// Heal restores every cut link. Faults set on them stay in place.
+func (l *Links) Heal() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cuts = make(map[link]bool)
+}
+
+// Cuts lists the cut links as [from, to] pairs, in order.
+func (l *Links) Cuts() [][2]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cuts := make([][2]int, 0, len(l.cuts))
+	for k := range l.cuts {
+		cuts = append(cuts, [2]int{k.from, k.to})
+	}
+	slices.SortFunc(cuts, func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	})
+	return cuts
+}
+
+// send decides the fate of a message of size bytes put on the link from ->
+// to at now: whether it is dropped, how long it takes to arrive and whether
+// it arrives twice.
+func (l *Links) send(from, to int, now time.Time, size func() int) (drop bool, delay time.Duration, duplicate bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := link{from, to}
+	if l.cuts[key] {
+		return true, 0, false
+	}
+	f, ok := l.faults[key]
+	if !ok {
+		return false, 0, false
+	}
+	if f.Drop > 0 && l.rand.Float64() < f.Drop {
+		return true, 0, false
+	}
+
+	delay = f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(l.rand.Int63n(int64(f.Jitter) + 1))
+	}
+	if f.Bandwidth > 0 {
+		// the message goes out once the ones before it are through.
+		start := now
+		if busy := l.busy[key]; busy.After(start) {
+			start = busy
+		}
+		done := start.Add(time.Duration(float64(size()) / float64(f.Bandwidth) * float64(time.Second)))
+		l.busy[key] = done
+		delay += done.Sub(now)
+	}
+	if f.Reorder > 0 && f.ReorderWindow > 0 && l.rand.Float64() < f.Reorder {
+		delay += time.Duration(l.rand.Int63n(int64(f.ReorderWindow) + 1))
+	}
+	duplicate = f.Duplicate > 0 && l.rand.Float64() < f.Duplicate
+	return false, delay, duplicate
+}
+
+// encodedSize is how many bytes v takes on the wire.
+func encodedSize(v any) int {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return 0
+	}
+	return buf.Len()
+}
+
+// callOverLinks carries a call across the link to peerId and its reply back
+// across the link from it, applying the faults of both.
+func (rpp *RPCProxy) callOverLinks(links *Links, t Transport, peerId int, method string, args any, reply any) error {
+	id, clock := rpp.rf.id, rpp.rf.clock
+
+	drop, delay, duplicate := links.send(id, peerId, clock.Now(), func() int { return encodedSize(args) })
+	if drop {
+		rpp.logLinkDropped(id, peerId, method, "request")
+		return fmt.Errorf("%s to %d: %w", method, peerId, ErrLinkDropped)
+	}
+	if delay > 0 {
+		<-clock.After(delay)
+	}
+	if duplicate {
+		go func() {
+			dup := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+			_ = t.Call(peerId, method, args, dup)
+		}()
+	}
+	if err := t.Call(peerId, method, args, reply); err != nil {
+		return err
+	}
+
+	drop, delay, _ = links.send(peerId, id, clock.Now(), func() int { return encodedSize(reply) })
+	if drop {
+		rpp.logLinkDropped(peerId, id, method, "reply")
+		return fmt.Errorf("%s reply from %d: %w", method, peerId, ErrLinkDropped)
+	}
+	if delay > 0 {
+		<-clock.After(delay)
+	}
+	return nil
+}
+
+func (rpp *RPCProxy) logLinkDropped(from, to int, method string, leg string) {
+	rpp.rf.logger.Info().
+		Int("raftID", from).
+		Int("peer", to).
+		Str("method", method).
+		Str("leg", leg).
+		Msg("linkDropped")
+}
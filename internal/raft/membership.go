@@ -0,0 +1,272 @@
+// MEMBERSHIP
+// Single-server cluster membership changes (section 4.1 of the Raft thesis).
+// A configuration is just a log entry carrying the full voter and learner
+// sets; every node uses the latest configuration in its log, committed or not.
+// Learners (section 4.2.1) get the log replicated like any follower but never
+// vote and don't count towards commit, so a new server can catch up before it
+// is promoted without hurting availability.
+package raft
+
+import (
+	"encoding/gob"
+	"errors"
+	"slices"
+)
+
+// ConfigEntry is the log command that switches the cluster to a new voter
+// and learner set.
+type ConfigEntry struct {
+	Voters   []int
+	Learners []int
+}
+
+func init() {
+	gob.Register(ConfigEntry{})
+}
+
+func (c ConfigEntry) clone() ConfigEntry {
+	return ConfigEntry{Voters: slices.Clone(c.Voters), Learners: slices.Clone(c.Learners)}
+}
+
+var (
+	ErrConfigChangePending = errors.New("raft: previous configuration change not committed yet")
+	ErrAlreadyMember       = errors.New("raft: server is already a voter")
+	ErrNotMember           = errors.New("raft: server is not a voter")
+	ErrNotLearner          = errors.New("raft: server is not a learner")
+	ErrLearnerBehind       = errors.New("raft: learner has not caught up yet")
+)
+
+// AddVoter appends a configuration entry adding peerId to the voters and
+// returns its log index. The new server should already be running and
+// reachable so it can catch up through AppendEntries/InstallSnapshot.
+// A learner passed here is promoted right away, caught up or not.
+func (rf *Raft) AddVoter(peerId int) (int, error) {
+	return rf.changeConfig(func(voters, learners []int) (ConfigEntry, error) {
+		if slices.Contains(voters, peerId) {
+			return ConfigEntry{}, ErrAlreadyMember
+		}
+		return ConfigEntry{Voters: append(voters, peerId), Learners: without(learners, peerId)}, nil
+	})
+}
+
+// RemoveVoter appends a configuration entry removing peerId from the voters.
+// A leader removing itself steps down once the entry commits.
+func (rf *Raft) RemoveVoter(peerId int) (int, error) {
+	return rf.changeConfig(func(voters, learners []int) (ConfigEntry, error) {
+		if !slices.Contains(voters, peerId) {
+			return ConfigEntry{}, ErrNotMember
+		}
+		return ConfigEntry{Voters: without(voters, peerId), Learners: learners}, nil
+	})
+}
+
+// AddLearner appends a configuration entry adding peerId as a learner.
+func (rf *Raft) AddLearner(peerId int) (int, error) {
+	return rf.changeConfig(func(voters, learners []int) (ConfigEntry, error) {
+		if slices.Contains(voters, peerId) || slices.Contains(learners, peerId) {
+			return ConfigEntry{}, ErrAlreadyMember
+		}
+		return ConfigEntry{Voters: voters, Learners: append(learners, peerId)}, nil
+	})
+}
+
+// RemoveLearner appends a configuration entry dropping the learner peerId.
+func (rf *Raft) RemoveLearner(peerId int) (int, error) {
+	return rf.changeConfig(func(voters, learners []int) (ConfigEntry, error) {
+		if !slices.Contains(learners, peerId) {
+			return ConfigEntry{}, ErrNotLearner
+		}
+		return ConfigEntry{Voters: voters, Learners: without(learners, peerId)}, nil
+	})
+}
+
+// PromoteLearner turns the learner peerId into a voter, but only once its
+// matchIndex reached the leader's commitIndex. Until then it returns
+// ErrLearnerBehind and the caller is expected to retry.
+func (rf *Raft) PromoteLearner(peerId int) (int, error) {
+	index, err := rf.changeConfig(func(voters, learners []int) (ConfigEntry, error) {
+		if !slices.Contains(learners, peerId) {
+			return ConfigEntry{}, ErrNotLearner
+		}
+		if rf.state != Leader {
+			return ConfigEntry{}, ErrNotLeader
+		}
+		if rf.matchIndex[peerId] < rf.commitIndex {
+			return ConfigEntry{}, ErrLearnerBehind
+		}
+		return ConfigEntry{Voters: append(voters, peerId), Learners: without(learners, peerId)}, nil
+	})
+	if err == nil {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("peer", peerId).
+			Msg("learnerPromoted")
+	}
+	return index, err
+}
+
+// Configuration returns the voter set this node is currently using.
+func (rf *Raft) Configuration() []int {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return slices.Clone(rf.config)
+}
+
+// Learners returns the learner set this node is currently using.
+func (rf *Raft) Learners() []int {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return slices.Clone(rf.learners)
+}
+
+// changeConfig appends the configuration entry change derives from the current
+// voter and learner sets, passed as copies it may modify. Both are read and
+// the entry appended under the same lock, so concurrent changes can't build
+// on the same stale configuration.
+func (rf *Raft) changeConfig(change func(voters, learners []int) (ConfigEntry, error)) (int, error) {
+	rf.mu.Lock()
+	cfg, err := change(slices.Clone(rf.config), slices.Clone(rf.learners))
+	if err != nil {
+		rf.mu.Unlock()
+		return -1, err
+	}
+	if rf.state != Leader {
+		rf.mu.Unlock()
+		return -1, ErrNotLeader
+	}
+	if rf.transferTarget >= 0 {
+		rf.mu.Unlock()
+		return -1, ErrTransferInProgress
+	}
+	// one server at a time: the previous change must be committed first, and
+	// so must an entry of this term. Until the new leader's no-op commits, a
+	// change made by a deposed leader may still commit behind our back.
+	if rf.configIndex > rf.commitIndex || rf.commitIndex < 0 || rf.termAt(rf.commitIndex) != rf.currentTerm {
+		rf.mu.Unlock()
+		return -1, ErrConfigChangePending
+	}
+	slices.Sort(cfg.Voters)
+	slices.Sort(cfg.Learners)
+	submitIndex := rf.logLen()
+	rf.log = append(rf.log, LogEntry{Command: cfg, Term: rf.currentTerm})
+	rf.applyConfig()
+	if err := rf.persist(rf.stageEntries(submitIndex)); err != nil {
+		rf.fault(err)
+		rf.mu.Unlock()
+		return -1, ErrStorageFault
+	}
+	rf.triggerAE()
+	rf.advanceCommitIndex()
+	rf.mu.Unlock()
+	return submitIndex, nil
+}
+
+// configAt returns the configuration in effect at index along with the index
+// of the entry that introduced it (snapshotIndex for the base config).
+// Expects rf.mu to be locked.
+func (rf *Raft) configAt(index int) (ConfigEntry, int) {
+	for i := index; i > rf.snapshotIndex; i-- {
+		if cfg, ok := rf.log[rf.logPos(i)].Command.(ConfigEntry); ok {
+			return cfg, i
+		}
+	}
+	return rf.baseConfig, rf.snapshotIndex
+}
+
+// applyConfig switches to the latest configuration in the log. It must be
+// called whenever the log is appended to, truncated or replaced by a
+// snapshot. Expects rf.mu to be locked.
+func (rf *Raft) applyConfig() {
+	cfg, configIndex := rf.configAt(rf.logLen() - 1)
+	rf.configIndex = configIndex
+	if slices.Equal(cfg.Voters, rf.config) && slices.Equal(cfg.Learners, rf.learners) {
+		return
+	}
+
+	oldConfig := rf.config
+	oldLearners := rf.learners
+	rf.config = cfg.Voters
+	rf.learners = cfg.Learners
+	rf.peerIds = without(cfg.Voters, rf.id)
+	rf.updateLearnerState()
+
+	if rf.state == Leader {
+		for _, peerId := range rf.replicaIds() {
+			if _, ok := rf.nextIndex[peerId]; !ok {
+				rf.nextIndex[peerId] = rf.logLen()
+				rf.matchIndex[peerId] = -1
+				rf.lastContact[peerId] = rf.clock.Now()
+			}
+		}
+	}
+
+	if oldConfig == nil {
+		// initial configuration, nothing transitioned.
+		return
+	}
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Str("state", rf.state.String()).
+		Ints("oldVoters", oldConfig).
+		Ints("newVoters", cfg.Voters).
+		Ints("oldLearners", oldLearners).
+		Ints("newLearners", cfg.Learners).
+		Int("configIndex", configIndex).
+		Msg("configChange")
+}
+
+// updateLearnerState moves this node between Follower and Learner when the
+// configuration adds it as a learner or promotes it. Expects rf.mu to be locked.
+func (rf *Raft) updateLearnerState() {
+	var newState RfState
+	switch {
+	case rf.state == Follower && rf.isLearner():
+		newState = Learner
+	case rf.state == Learner && !rf.isLearner():
+		newState = Follower
+	default:
+		return
+	}
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Str("oldState", rf.state.String()).
+		Str("newState", newState.String()).
+		Msg("stateTransition")
+
+	rf.state = newState
+	if newState == Follower {
+		// promoted: from now on this node may campaign.
+		rf.electionResetEvent = rf.clock.Now()
+		rf.startElectionTimer()
+	}
+}
+
+// isVoter reports whether this node belongs to its current configuration.
+// Expects rf.mu to be locked.
+func (rf *Raft) isVoter() bool {
+	return slices.Contains(rf.config, rf.id)
+}
+
+// quorum reports whether count votes/acks make a majority of the voters.
+// Expects rf.mu to be locked.
+func (rf *Raft) quorum(count int) bool {
+	return count*2 > len(rf.config)
+}
+
+// isLearner reports whether this node is a learner in its current
+// configuration. Expects rf.mu to be locked.
+func (rf *Raft) isLearner() bool {
+	return slices.Contains(rf.learners, rf.id)
+}
+
+// replicaIds returns every other server the leader replicates to: the
+// voters in peerIds followed by the learners. Expects rf.mu to be locked.
+func (rf *Raft) replicaIds() []int {
+	return append(slices.Clone(rf.peerIds), without(rf.learners, rf.id)...)
+}
+
+// without returns a copy of ids with id left out.
+func without(ids []int, id int) []int {
+	return slices.DeleteFunc(slices.Clone(ids), func(x int) bool { return x == id })
+}
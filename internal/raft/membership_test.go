@@ -0,0 +1,169 @@
+package raft
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// followerOf returns a node of c other than leader.
+func followerOf(c *testCluster, leader *Server) *Server {
+	for _, s := range c.servers {
+		if s != leader {
+			return s
+		}
+	}
+	return nil
+}
+
+// waitConfigCommitted waits until leader committed index and every node in
+// nodes uses voters as its configuration.
+func waitConfigCommitted(tb testing.TB, leader *Server, index int, voters []int, nodes ...*Server) {
+	tb.Helper()
+	waitFor(tb, "the configuration change to commit", func() bool {
+		if leader.rf.Inspect().CommitIndex < index {
+			return false
+		}
+		for _, s := range nodes {
+			if !slices.Equal(s.Configuration(), voters) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestChangeConfigWaitsForLeaderNoop(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+	for _, s := range c.servers {
+		s.DisconnectAll()
+	}
+
+	// the leader as it is right after winning the next term: its no-op is
+	// in the log but no follower has it yet.
+	rf := leader.rf
+	rf.mu.Lock()
+	rf.currentTerm++
+	rf.log = append(rf.log, LogEntry{Command: NoOpEntry{LeaderId: rf.id}, Term: rf.currentTerm})
+	rf.mu.Unlock()
+
+	if _, err := leader.RemoveVoter(follower.serverId); !errors.Is(err, ErrConfigChangePending) {
+		t.Errorf("RemoveVoter before the no-op committed: got %v, want ErrConfigChangePending", err)
+	}
+	if got := leader.Configuration(); len(got) != 3 {
+		t.Errorf("configuration changed to %v", got)
+	}
+}
+
+func TestChangeConfigOneAtATime(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+	for _, s := range c.servers {
+		s.DisconnectAll()
+	}
+
+	if _, err := leader.RemoveVoter(follower.serverId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.AddLearner(3); !errors.Is(err, ErrConfigChangePending) {
+		t.Errorf("AddLearner with the removal uncommitted: got %v, want ErrConfigChangePending", err)
+	}
+}
+
+func TestChangeConfigRejectsNoOpChanges(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+
+	tests := []struct {
+		name   string
+		change func() (int, error)
+		want   error
+	}{
+		{"AddVoter of a voter", func() (int, error) { return leader.AddVoter(follower.serverId) }, ErrAlreadyMember},
+		{"AddLearner of a voter", func() (int, error) { return leader.AddLearner(follower.serverId) }, ErrAlreadyMember},
+		{"RemoveVoter of a stranger", func() (int, error) { return leader.RemoveVoter(7) }, ErrNotMember},
+		{"RemoveLearner of a voter", func() (int, error) { return leader.RemoveLearner(follower.serverId) }, ErrNotLearner},
+		{"PromoteLearner of a voter", func() (int, error) { return leader.PromoteLearner(follower.serverId) }, ErrNotLearner},
+		{"AddLearner on a follower", func() (int, error) { return follower.AddLearner(7) }, ErrNotLeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if index, err := tt.change(); !errors.Is(err, tt.want) || index != -1 {
+				t.Errorf("got %d, %v, want -1, %v", index, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveAndAddVoter(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+	var others []*Server
+	var voters []int
+	for _, s := range c.servers {
+		if s != follower {
+			others = append(others, s)
+			voters = append(voters, s.serverId)
+		}
+	}
+
+	index, err := leader.RemoveVoter(follower.serverId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConfigCommitted(t, leader, index, voters, others...)
+
+	leader = c.settledLeader(t)
+	index, err = leader.AddVoter(follower.serverId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConfigCommitted(t, leader, index, []int{0, 1, 2}, c.servers...)
+}
+
+func TestLearnerPromotion(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	c.fill(t, 50)
+	learner := c.join(t)
+	id := learner.serverId
+
+	leader := c.settledLeader(t)
+	index, err := leader.AddLearner(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConfigCommitted(t, leader, index, []int{0, 1, 2}, c.servers...)
+	waitFor(t, "the learner to learn it is one", func() bool {
+		return slices.Equal(learner.Learners(), []int{id})
+	})
+	learner.rf.mu.Lock()
+	state := learner.rf.state
+	learner.rf.mu.Unlock()
+	if state != Learner {
+		t.Fatalf("learner is %v, want Learner", state)
+	}
+
+	waitFor(t, "the learner to be promoted", func() bool {
+		leader = c.settledLeader(t)
+		index, err = leader.PromoteLearner(id)
+		return err == nil
+	})
+	waitConfigCommitted(t, leader, index, []int{0, 1, 2, 3}, c.servers...)
+	if got := leader.Learners(); len(got) != 0 {
+		t.Errorf("learners left after the promotion: %v", got)
+	}
+	learner.rf.mu.Lock()
+	state = learner.rf.state
+	learner.rf.mu.Unlock()
+	if state != Follower {
+		t.Errorf("promoted learner is %v, want Follower", state)
+	}
+	waitFor(t, "the promoted learner to commit its promotion", func() bool {
+		return learner.rf.Inspect().CommitIndex >= index
+	})
+}
@@ -0,0 +1,58 @@
+// OBSERVER
+// A window onto every node of a cluster for whoever watches it from outside,
+// e.g. a checker of Raft's safety properties running alongside a
+// simulation. Nodes report elections and applied entries to the Observer
+// set in their Config as they happen, and Inspect copies out a node's log
+// at any time.
+package raft
+
+import "slices"
+
+// Observer is told what the nodes sharing it through Config.Observer do.
+// Nodes call it concurrently, some with their lock held, so it must not
+// call back into them.
+type Observer interface {
+	// LeaderElected is called when node id becomes leader of term, with its
+	// log as it stands then, the new leader's no-op included.
+	LeaderElected(id int, term int, log LogView)
+
+	// Applied is called for every entry node id hands to its application,
+	// in index order; for a snapshot, once for all the entries it covers.
+	Applied(id int, entry CommitEntry)
+}
+
+// LogView is a copy of a node's log: the snapshot covering its compacted
+// prefix, if any, and the entries following it.
+type LogView struct {
+	Term          int
+	CommitIndex   int
+	SnapshotIndex int // -1 without a snapshot
+	SnapshotTerm  int
+	Entries       []LogEntry // the entry at index SnapshotIndex+1 first
+}
+
+// Inspect returns a copy of the node's log.
+func (rf *Raft) Inspect() LogView {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.logView()
+}
+
+// logView expects rf.mu to be locked.
+func (rf *Raft) logView() LogView {
+	return LogView{
+		Term:          rf.currentTerm,
+		CommitIndex:   rf.commitIndex,
+		SnapshotIndex: rf.snapshotIndex,
+		SnapshotTerm:  rf.snapshotTerm,
+		Entries:       slices.Clone(rf.log),
+	}
+}
+
+// deliver sends entry to the application and tells the observer.
+func (rf *Raft) deliver(entry CommitEntry) {
+	rf.commitChan <- entry
+	if rf.cfg.Observer != nil {
+		rf.cfg.Observer.Applied(rf.id, entry)
+	}
+}
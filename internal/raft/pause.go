@@ -0,0 +1,88 @@
+// PAUSE
+// A paused node is stalled the way a long GC pause or a frozen VM stalls a
+// process: its connections stay open and peers' calls still reach it, but it
+// handles nothing until it resumes. Time goes on meanwhile, so it wakes up
+// acting on what it believed before, e.g. that it is still the leader.
+package raft
+
+import (
+	"time"
+)
+
+// Pause freezes the node for d on its clock: its election timer, heartbeats,
+// the RPCs it serves, the replies to its own calls, client requests and
+// commit delivery all wait until it resumes. It fails with ErrPaused if the
+// node is paused already.
+func (rf *Raft) Pause(d time.Duration) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	switch {
+	case rf.state == Dead:
+		return ErrStopped
+	case rf.resumed != nil:
+		return ErrPaused
+	}
+
+	resumed := make(chan struct{})
+	rf.resumed = resumed
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Str("state", rf.state.String()).
+		Int64("durationMs", d.Milliseconds()).
+		Msg("nodePaused")
+
+	if rf.cfg.TickDriven {
+		// the first Tick after d has passed resumes the node.
+		rf.pausedUntil = rf.clock.Now().Add(d)
+		return nil
+	}
+	go func() {
+		<-rf.clock.After(d)
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		if rf.resumed != resumed {
+			return
+		}
+		rf.resume()
+	}()
+	return nil
+}
+
+// resume ends the pause. Expects rf.mu to be locked.
+func (rf *Raft) resume() {
+	rf.endPause()
+	// what the node still believes: nothing it missed has reached it yet.
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Str("state", rf.state.String()).
+		Int("leaderId", rf.leaderId).
+		Msg("nodeResumed")
+}
+
+// paused reports whether the node is paused.
+func (rf *Raft) paused() bool {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.resumed != nil
+}
+
+// endPause lets everything waiting in waitResumed through. Expects rf.mu to
+// be locked.
+func (rf *Raft) endPause() {
+	if rf.resumed != nil {
+		close(rf.resumed)
+		rf.resumed = nil
+	}
+}
+
+// waitResumed blocks while the node is paused. Expects rf.mu to be unlocked.
+func (rf *Raft) waitResumed() {
+	rf.mu.Lock()
+	resumed := rf.resumed
+	rf.mu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
@@ -1,61 +1,195 @@
 // PERSISTENCE
 // ALSO from figure 2 of paper
-// Persister is a place for this server to
-// save its persistent State, and also initially holds the most
-// recent saved State, if any.
-// restore From Storage restores the persistent state of this Rf from storage.
+// Every state change writes only what it changed, as one batch, so a crash
+// never leaves half of it behind. A node whose storage fails goes Faulted.
 package raft
 
 import (
 	"bytes"
 	"encoding/gob"
-	"log"
+	"fmt"
 )
 
-func (rf *Raft) restoreFromStorage() {
-	if termData, found := rf.storage.Get("currentTerm"); found {
-		d := gob.NewDecoder(bytes.NewBuffer(termData))
-		if err := d.Decode(&rf.currentTerm); err != nil {
-			log.Fatal(err)
+// hardState is what a node promised its peers about terms and votes.
+type hardState struct {
+	term     int
+	votedFor int
+}
+
+func (rf *Raft) restoreFromStorage() error {
+	// the hard state may be missing if the node stopped right after its
+	// first entries were stored; it then still is term 0 without a vote.
+	if _, err := rf.getGob("currentTerm", &rf.currentTerm); err != nil {
+		return err
+	}
+	if _, err := rf.getGob("votedFor", &rf.votedFor); err != nil {
+		return err
+	}
+	// snapshot keys are only present once the log has been compacted.
+	if _, err := rf.getGob("snapshotIndex", &rf.snapshotIndex); err != nil {
+		return err
+	}
+	if _, err := rf.getGob("snapshotTerm", &rf.snapshotTerm); err != nil {
+		return err
+	}
+	if _, err := rf.getGob("config", &rf.baseConfig); err != nil {
+		return err
+	}
+	snapshot, found, err := rf.storage.Get("snapshot")
+	if err != nil {
+		return err
+	}
+	if found {
+		rf.snapshot = snapshot
+	}
+
+	first, entries, err := rf.storage.Entries()
+	if err != nil {
+		return err
+	}
+	if first > rf.snapshotIndex+1 {
+		return fmt.Errorf("log in storage starts at %d, past snapshot index %d", first, rf.snapshotIndex)
+	}
+	rf.log = nil
+	for i, data := range entries {
+		if first+i <= rf.snapshotIndex {
+			// compacted, but the storage had not dropped it yet.
+			continue
 		}
-	} else {
-		log.Fatal("currentTerm not found in storage")
+		var entry LogEntry
+		if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&entry); err != nil {
+			return fmt.Errorf("decoding entry %d: %w", first+i, err)
+		}
+		rf.log = append(rf.log, entry)
 	}
-	if votedData, found := rf.storage.Get("votedFor"); found {
-		d := gob.NewDecoder(bytes.NewBuffer(votedData))
-		if err := d.Decode(&rf.votedFor); err != nil {
-			log.Fatal(err)
+	return nil
+}
+
+// persist commits what each of stages adds to one batch, so a crash leaves
+// either every change on disk or none of them: never, say, a new currentTerm
+// next to the vote cast in the previous one. Must be called before answering
+// an RPC or sending one whenever the persistent state changed.
+func (rf *Raft) persist(stages ...func(b *Batch) error) error {
+	b := new(Batch)
+	for _, stage := range stages {
+		if err := stage(b); err != nil {
+			return err
 		}
-	} else {
-		log.Fatal("votedFor not found in storage")
 	}
-	if logData, found := rf.storage.Get("log"); found {
-		d := gob.NewDecoder(bytes.NewBuffer(logData))
-		if err := d.Decode(&rf.log); err != nil {
-			log.Fatal(err)
+	if b.Empty() {
+		return nil
+	}
+	return rf.storage.Commit(b)
+}
+
+// stageHardState adds currentTerm and votedFor to b if they changed since
+// they were last stored. A failed persist faults the node for good, so what
+// was staged counts as stored right away.
+func (rf *Raft) stageHardState(b *Batch) error {
+	hs := hardState{term: rf.currentTerm, votedFor: rf.votedFor}
+	if hs == rf.storedHardState {
+		return nil
+	}
+	if err := stageGob(b, "currentTerm", rf.currentTerm); err != nil {
+		return err
+	}
+	if err := stageGob(b, "votedFor", rf.votedFor); err != nil {
+		return err
+	}
+	rf.storedHardState = hs
+	return nil
+}
+
+// stageEntries returns a stage saving the log from index on, replacing
+// whatever the storage held from there. Callers pass the first index they
+// appended or overwrote.
+func (rf *Raft) stageEntries(index int) func(b *Batch) error {
+	return func(b *Batch) error {
+		entries := make([][]byte, 0, rf.logLen()-index)
+		for _, entry := range rf.log[rf.logPos(index):] {
+			var data bytes.Buffer
+			if err := gob.NewEncoder(&data).Encode(entry); err != nil {
+				return err
+			}
+			entries = append(entries, data.Bytes())
 		}
-	} else {
-		log.Fatal("log not found in storage")
+		b.AppendEntries(index, entries)
+		return nil
+	}
+}
+
+// stageSnapshot adds the snapshot with the index, term and configuration it
+// covers to b, and drops the compacted prefix from the stored log.
+func (rf *Raft) stageSnapshot(b *Batch) error {
+	if err := stageGob(b, "snapshotIndex", rf.snapshotIndex); err != nil {
+		return err
+	}
+	if err := stageGob(b, "snapshotTerm", rf.snapshotTerm); err != nil {
+		return err
 	}
+	if err := stageGob(b, "config", rf.baseConfig); err != nil {
+		return err
+	}
+	b.Set("snapshot", rf.snapshot)
+
+	b.CompactEntries(rf.snapshotIndex + 1)
+	if len(rf.log) == 0 {
+		// the snapshot replaced the whole log, including any conflicting
+		// entries past it.
+		b.AppendEntries(rf.snapshotIndex+1, nil)
+	}
+	return nil
 }
 
-// persistToStorage saves all of Rf's persistent state in rf.storage.
-func (rf *Raft) persistToStorage() {
-	var termData bytes.Buffer
-	if err := gob.NewEncoder(&termData).Encode(rf.currentTerm); err != nil {
-		log.Fatal(err)
+func stageGob(b *Batch, key string, v any) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(v); err != nil {
+		return fmt.Errorf("encoding %s: %w", key, err)
 	}
-	rf.storage.Set("currentTerm", termData.Bytes())
+	b.Set(key, data.Bytes())
+	return nil
+}
 
-	var votedData bytes.Buffer
-	if err := gob.NewEncoder(&votedData).Encode(rf.votedFor); err != nil {
-		log.Fatal(err)
+func (rf *Raft) getGob(key string, v any) (bool, error) {
+	data, found, err := rf.storage.Get(key)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding %s: %w", key, err)
 	}
-	rf.storage.Set("votedFor", votedData.Bytes())
+	return true, nil
+}
 
-	var logData bytes.Buffer
-	if err := gob.NewEncoder(&logData).Encode(rf.log); err != nil {
-		log.Fatal(err)
+// fault takes this node out of the cluster after its storage failed: it
+// stops voting, acknowledging entries and leading, and fails everything
+// waiting on it. Only Kill moves it on from here. Expects rf.mu to be locked.
+func (rf *Raft) fault(err error) {
+	if rf.state == Dead || rf.state == Faulted {
+		return
 	}
-	rf.storage.Set("log", logData.Bytes())
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Str("error", err.Error()).
+		Msg("storageFault")
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Str("oldState", rf.state.String()).
+		Str("newState", Faulted.String()).
+		Str("reason", "storageFault").
+		Msg("stateTransition")
+
+	rf.state = Faulted
+	rf.faultErr = err
+	rf.leaderId = -1
+	rf.failPendingReads(ErrStorageFault)
+	rf.failProposals(ErrStorageFault)
+}
+
+// Fault reports the storage error that faulted this node, or nil.
+func (rf *Raft) Fault() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.faultErr
 }
@@ -0,0 +1,352 @@
+package raft
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// testCluster is an n-node cluster on a ChannelNetwork, its events logged
+// nowhere.
+type testCluster struct {
+	servers    []*Server
+	cfg        Config
+	newStorage func(id int) Storage
+
+	// set on virtual time, where the cluster only moves on in step.
+	clock   *VirtualClock
+	network *ChannelNetwork
+}
+
+func newTestCluster(tb testing.TB, n int, newStorage func(id int) Storage) *testCluster {
+	tb.Helper()
+	cfg := DefaultConfig()
+	cfg.NewTransport = NewChannelNetwork().Transport
+	c := &testCluster{cfg: cfg, newStorage: newStorage}
+	c.startAll(tb, n)
+	return c
+}
+
+// newVirtualTestCluster is newTestCluster on virtual time: its nodes are
+// TickDriven on a queued network and only move on in step, so a test runs
+// the same way every time.
+func newVirtualTestCluster(tb testing.TB, n int) *testCluster {
+	tb.Helper()
+	cfg := DefaultConfig()
+	clock := NewVirtualClock(time.Unix(0, 0).UTC())
+	network := NewQueuedChannelNetwork(clock)
+	cfg.Clock = clock
+	cfg.Seed = 1
+	cfg.TickDriven = true
+	cfg.NewTransport = network.Transport
+	c := &testCluster{
+		cfg:        cfg,
+		newStorage: func(int) Storage { return NewMapStorage() },
+		clock:      clock,
+		network:    network,
+	}
+	c.startAll(tb, n)
+	return c
+}
+
+// startAll starts n connected servers.
+func (c *testCluster) startAll(tb testing.TB, n int) {
+	tb.Helper()
+	nop := zerolog.Nop()
+	c.cfg.Logger = &nop
+	ready := make(chan any)
+	for id := range n {
+		var peerIds []int
+		for p := range n {
+			if p != id {
+				peerIds = append(peerIds, p)
+			}
+		}
+		c.start(tb, id, peerIds, false, ready)
+	}
+	for _, s := range c.servers {
+		c.connect(tb, s)
+	}
+	close(ready)
+	tb.Cleanup(c.shutdown)
+}
+
+// step advances the virtual clock by one TickInterval, ticks every node and
+// delivers the messages due by then.
+func (c *testCluster) step() {
+	c.clock.Advance(c.cfg.TickInterval)
+	for _, s := range c.servers {
+		s.Tick()
+	}
+	c.network.Deliver()
+	// commit delivery runs on goroutines of its own.
+	runtime.Gosched()
+}
+
+// run steps the cluster for d of virtual time.
+func (c *testCluster) run(d time.Duration) {
+	for deadline := c.clock.Now().Add(d); c.clock.Now().Before(deadline); {
+		c.step()
+	}
+}
+
+// runUntil steps the cluster until cond holds, failing tb if it doesn't
+// within a minute of virtual time.
+func (c *testCluster) runUntil(tb testing.TB, what string, cond func() bool) {
+	tb.Helper()
+	for deadline := c.clock.Now().Add(time.Minute); c.clock.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		c.step()
+	}
+	tb.Fatalf("timed out waiting for %s", what)
+}
+
+// await runs op, which blocks on the cluster, stepping the cluster until op
+// returns.
+func (c *testCluster) await(tb testing.TB, op func()) {
+	tb.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		op()
+	}()
+	c.runUntil(tb, "the call to return", func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			// give op a chance to start waiting before time moves on.
+			time.Sleep(100 * time.Microsecond)
+			return false
+		}
+	})
+}
+
+// isolate cuts s off from every other server; connect undoes it.
+func (c *testCluster) isolate(s *Server) {
+	s.DisconnectAll()
+	for _, peer := range c.servers {
+		if peer != s {
+			_ = peer.DisconnectPeer(s.serverId)
+		}
+	}
+}
+
+// start creates server id and has it serve, not connected to anyone yet.
+func (c *testCluster) start(tb testing.TB, id int, peerIds []int, joining bool, ready <-chan any) *Server {
+	tb.Helper()
+	commitChan := make(chan CommitEntry)
+	go func() {
+		for range commitChan {
+		}
+	}()
+	newServer := NewServer
+	if joining {
+		newServer = NewJoiningServer
+	}
+	s, err := newServer(id, peerIds, c.newStorage(id), ready, commitChan, c.cfg, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s.Serve()
+	c.servers = append(c.servers, s)
+	return s
+}
+
+// connect connects s and every other server of the cluster both ways.
+func (c *testCluster) connect(tb testing.TB, s *Server) {
+	tb.Helper()
+	for peerId, peer := range c.servers {
+		if peer == s {
+			continue
+		}
+		if err := s.ConnectToPeer(peerId, peer.GetListenAddr()); err != nil {
+			tb.Fatal(err)
+		}
+		if err := peer.ConnectToPeer(s.serverId, s.GetListenAddr()); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// join starts a server outside the configuration, connected to the rest of
+// the cluster, for the leader to add.
+func (c *testCluster) join(tb testing.TB) *Server {
+	tb.Helper()
+	id := len(c.servers)
+	var peerIds []int
+	for p := range id {
+		peerIds = append(peerIds, p)
+	}
+	ready := make(chan any)
+	close(ready)
+	s := c.start(tb, id, peerIds, true, ready)
+	c.connect(tb, s)
+	return s
+}
+
+// leader waits for a node to win an election and returns it.
+func (c *testCluster) leader(tb testing.TB) *Server {
+	tb.Helper()
+	if c.clock != nil {
+		var leader *Server
+		c.runUntil(tb, "a leader", func() bool {
+			leader = c.currentLeader()
+			return leader != nil
+		})
+		return leader
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		for _, s := range c.servers {
+			if s.IsLeader() {
+				return s
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatal("no leader elected")
+	return nil
+}
+
+// currentLeader returns a node that believes it leads, or nil.
+func (c *testCluster) currentLeader() *Server {
+	for _, s := range c.servers {
+		if s.IsLeader() {
+			return s
+		}
+	}
+	return nil
+}
+
+// settledLeader waits for a leader that committed an entry of its own term,
+// so that it takes configuration changes, and returns it.
+func (c *testCluster) settledLeader(tb testing.TB) *Server {
+	tb.Helper()
+	var leader *Server
+	settled := func() bool {
+		leader = c.leader(tb)
+		rf := leader.rf
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		return rf.state == Leader && rf.commitIndex >= 0 && rf.termAt(rf.commitIndex) == rf.currentTerm
+	}
+	if c.clock != nil {
+		c.runUntil(tb, "the leader's no-op to commit", settled)
+	} else {
+		waitFor(tb, "the leader's no-op to commit", settled)
+	}
+	return leader
+}
+
+// waitFor polls cond until it holds, failing tb if it doesn't within a few
+// seconds.
+func waitFor(tb testing.TB, what string, cond func() bool) {
+	tb.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	tb.Fatalf("timed out waiting for %s", what)
+}
+
+// fillBatch is how many entries go in before waiting for followers to
+// catch up; more at once overwhelm them and cost the leader its lease.
+const fillBatch = 1000
+
+// fill commits n entries, fillBatch at a time, and returns the leader.
+func (c *testCluster) fill(tb testing.TB, n int) *Server {
+	tb.Helper()
+	leader := c.leader(tb)
+	for i := 0; i < n; {
+		index := -1
+		for end := min(i+fillBatch, n); i < end; i++ {
+			if index = leader.Submit(i); index < 0 {
+				leader = c.leader(tb)
+				break
+			}
+		}
+		waitCommitted(leader, index)
+	}
+	return c.leader(tb)
+}
+
+// waitCommitted waits until leader committed index, or is leader no more.
+func waitCommitted(leader *Server, index int) {
+	for index >= 0 && leader.IsLeader() && leader.rf.Inspect().CommitIndex < index {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *testCluster) shutdown() {
+	for _, s := range c.servers {
+		s.DisconnectAll()
+	}
+	for _, s := range c.servers {
+		if c.clock != nil {
+			// nobody steps the cluster through a handoff anymore.
+			s.Stop()
+		} else {
+			s.Shutdown()
+		}
+	}
+}
+
+// BenchmarkSubmit measures what one Submit costs the leader of a 3-node
+// cluster as its log grows. Only the new entry is persisted, so the cost
+// should stay flat however many entries are already in the log.
+func BenchmarkSubmit(b *testing.B) {
+	storages := []struct {
+		name string
+		open func(b *testing.B) func(id int) Storage
+	}{
+		{"memory", func(*testing.B) func(int) Storage {
+			return func(int) Storage { return NewMapStorage() }
+		}},
+		{"wal", func(b *testing.B) func(int) Storage {
+			return func(int) Storage {
+				opts := DefaultWALOptions()
+				opts.Sync = SyncNever
+				w, err := OpenWALStorage(b.TempDir(), opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.Cleanup(func() { w.Close() })
+				return w
+			}
+		}},
+	}
+	for _, storage := range storages {
+		for _, length := range []int{0, 10_000, 100_000} {
+			b.Run(fmt.Sprintf("%s/log=%d", storage.name, length), func(b *testing.B) {
+				c := newTestCluster(b, 3, storage.open(b))
+				leader := c.fill(b, length)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					index := leader.Submit(i)
+					if index < 0 {
+						// a node rotating a long WAL stalls long enough to
+						// cost an election now and then.
+						b.StopTimer()
+						leader = c.leader(b)
+						b.StartTimer()
+						continue
+					}
+					if (i+1)%fillBatch == 0 {
+						b.StopTimer()
+						waitCommitted(leader, index)
+						b.StartTimer()
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// PV RPC
+// PreVote from section 9.6 of the Raft thesis: before bumping its term a node
+// asks the cluster whether it could win. A partitioned node keeps failing the
+// pre-vote, so it never inflates its term and can't depose a healthy leader
+// when it comes back.
+package raft
+
+import (
+	"slices"
+)
+
+type PreVoteArgs struct {
+	Term         int // the term the candidate would campaign in
+	CandidateId  int
+	LastLogIndex int
+	LastLogTerm  int
+}
+
+type PreVoteReply struct {
+	Term        int
+	VoteGranted bool
+}
+
+// SetPreVote turns the PreVote round on or off for future elections.
+func (rf *Raft) SetPreVote(enabled bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.preVote = enabled
+}
+
+// PreVote answers whether this node would vote for the candidate in
+// args.Term. Unlike RequestVote it changes no state at all.
+func (rf *Raft) PreVote(args PreVoteArgs, reply *PreVoteReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state == Dead {
+		return nil
+	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
+
+	reply.Term = rf.currentTerm
+	reply.VoteGranted = false
+
+	if !slices.Contains(rf.config, args.CandidateId) || args.Term < rf.currentTerm {
+		return nil
+	}
+
+	// still hearing from a leader (or being one): no reason for an election.
+	if rf.state == Leader || rf.since(rf.lastLeaderContact) < rf.cfg.ElectionTimeoutMin {
+		return nil
+	}
+
+	localLastIndex, localLastTerm := rf.lastLogIndexAndTerm()
+	if args.LastLogTerm > localLastTerm ||
+		(args.LastLogTerm == localLastTerm && args.LastLogIndex >= localLastIndex) {
+		reply.VoteGranted = true
+	}
+	return nil
+}
+
+// startPreVote runs a pre-vote round for currentTerm+1 and only starts the
+// real election if a majority would grant its vote. Expects rf.mu to be locked.
+func (rf *Raft) startPreVote() {
+	savedCurrentTerm := rf.currentTerm
+	rf.electionResetEvent = rf.clock.Now()
+	savedLastLogIndex, savedLastLogTerm := rf.lastLogIndexAndTerm()
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", savedCurrentTerm).
+		Str("state", rf.state.String()).
+		Msg("preVoteStarted")
+
+	votesReceived := 1
+	repliesNeeded := len(rf.peerIds)
+	if repliesNeeded == 0 {
+		rf.startElection(false)
+		return
+	}
+
+	args := PreVoteArgs{
+		Term:         savedCurrentTerm + 1,
+		CandidateId:  rf.id,
+		LastLogIndex: savedLastLogIndex,
+		LastLogTerm:  savedLastLogTerm,
+	}
+
+	for _, pid := range rf.peerIds {
+		reply := new(PreVoteReply)
+		rf.server.send(pid, "Raft.PreVote", args, reply, func(err error) {
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+
+			repliesNeeded--
+
+			// the pre-vote is stale once the term moved or we already won.
+			if rf.currentTerm != savedCurrentTerm || rf.state == Leader || rf.state == Dead {
+				return
+			}
+
+			if err == nil {
+				rf.logger.Info().
+					Int("raftID", rf.id).
+					Int("term", reply.Term).
+					Str("state", rf.state.String()).
+					Bool("voteGranted", reply.VoteGranted).
+					Int("peer", pid).
+					Msg("recievePreVote")
+
+				if reply.Term > savedCurrentTerm {
+					rf.becomeFollower(reply.Term)
+					return
+				}
+				if reply.VoteGranted {
+					votesReceived++
+					if rf.quorum(votesReceived) {
+						rf.logger.Info().
+							Int("raftID", rf.id).
+							Int("term", savedCurrentTerm).
+							Str("state", rf.state.String()).
+							Msg("preVoteWon")
+						rf.startElection(false)
+						return
+					}
+				}
+			}
+
+			if repliesNeeded == 0 {
+				rf.logger.Info().
+					Int("raftID", rf.id).
+					Int("term", savedCurrentTerm).
+					Str("state", rf.state.String()).
+					Msg("preVoteLost")
+			}
+		})
+	}
+
+	rf.startElectionTimer()
+}
@@ -0,0 +1,93 @@
+package raft
+
+import "testing"
+
+func TestIsolatedFollowerTerm(t *testing.T) {
+	tests := []struct {
+		name     string
+		preVote  bool
+		wantBump bool
+	}{
+		{"with PreVote", true, false},
+		{"without PreVote", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newVirtualTestCluster(t, 3)
+			for _, s := range c.servers {
+				s.SetPreVote(tt.preVote)
+			}
+			leader := c.settledLeader(t)
+			term := leader.Inspect().Term
+			follower := followerOf(c, leader)
+
+			c.isolate(follower)
+			c.run(5 * c.cfg.ElectionTimeoutMax)
+			if bumped := follower.Inspect().Term > term; bumped != tt.wantBump {
+				t.Errorf("isolated follower moved to term %d from %d", follower.Inspect().Term, term)
+			}
+
+			c.connect(t, follower)
+			c.run(5 * c.cfg.ElectionTimeoutMax)
+			if tt.preVote {
+				// nothing disturbed the leader.
+				if !leader.IsLeader() || leader.Inspect().Term != term {
+					t.Errorf("leader %d deposed by the rejoining follower", leader.serverId)
+				}
+			}
+			if got := c.leader(t).Inspect().Term; got < follower.Inspect().Term {
+				t.Errorf("leader in term %d behind the follower's %d", got, follower.Inspect().Term)
+			}
+		})
+	}
+}
+
+func TestPreVoteRefusedWhileLeaderIsHeard(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	voter := followerOf(c, leader).rf
+	voter.mu.Lock()
+	term := voter.currentTerm
+	lastIndex, lastTerm := voter.lastLogIndexAndTerm()
+	voter.mu.Unlock()
+
+	args := PreVoteArgs{Term: term + 1, CandidateId: leader.serverId, LastLogIndex: lastIndex, LastLogTerm: lastTerm}
+	var reply PreVoteReply
+	if err := voter.PreVote(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.VoteGranted {
+		t.Error("pre-vote granted while the leader is still heard from")
+	}
+
+	// once the leader is gone for an election timeout the vote is granted,
+	// and giving it leaves the voter's term alone. Nobody can win an
+	// election meanwhile.
+	for _, s := range c.servers {
+		c.isolate(s)
+	}
+	c.run(c.cfg.ElectionTimeoutMin)
+	voter.mu.Lock()
+	term = voter.currentTerm
+	voter.mu.Unlock()
+	args.Term = term + 1
+	if err := voter.PreVote(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.VoteGranted {
+		t.Error("pre-vote refused with the leader gone")
+	}
+	if got := voter.Inspect().Term; got != term {
+		t.Errorf("pre-vote moved the voter to term %d from %d", got, term)
+	}
+
+	// a candidate whose log is behind never gets it.
+	args.LastLogTerm = 0
+	args.LastLogIndex = -1
+	if err := voter.PreVote(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.VoteGranted {
+		t.Error("pre-vote granted to a candidate with an empty log")
+	}
+}
@@ -0,0 +1,125 @@
+// PROPOSE
+// Proposals are Submit with a future attached: the caller learns whether its
+// entry was applied at the index it was given, in the term it was proposed
+// in, instead of guessing from what eventually shows up at that index.
+package raft
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// NotLeaderError is ErrNotLeader carrying this node's best guess at who the
+// leader is, or -1 if it has none.
+type NotLeaderError struct {
+	LeaderHint int
+}
+
+func (e *NotLeaderError) Error() string {
+	return fmt.Sprintf("%v (leader hint %d)", ErrNotLeader, e.LeaderHint)
+}
+
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// Proposal is the future returned by Propose.
+type Proposal struct {
+	ctx   context.Context
+	index int
+	term  int
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Index is the log index the entry was appended at, -1 if it never was.
+func (p *Proposal) Index() int {
+	return p.index
+}
+
+// Done is closed once the proposal resolved.
+func (p *Proposal) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the entry has been applied, returning its index and
+// term, or until the proposal failed. It fails with a *NotLeaderError if the
+// entry was never appended, ErrLeadershipLost if this node stepped down or
+// the entry was overwritten, ErrStopped if the node was killed, and
+// ErrTimeout once the context passed to Propose is done.
+func (p *Proposal) Wait() (int, int, error) {
+	select {
+	case <-p.done:
+		return p.index, p.term, p.err
+	case <-p.ctx.Done():
+		return p.index, p.term, ErrTimeout
+	}
+}
+
+func (p *Proposal) resolve(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.done)
+	})
+}
+
+// Propose appends command to the log if this node is the leader and returns
+// a future for its outcome. It never blocks on replication.
+func (rf *Raft) Propose(ctx context.Context, command any) *Proposal {
+	rf.waitResumed()
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	p := &Proposal{ctx: ctx, index: -1, term: rf.currentTerm, done: make(chan struct{})}
+	switch {
+	case rf.state == Dead:
+		p.resolve(ErrStopped)
+		return p
+	case rf.state != Leader:
+		p.resolve(&NotLeaderError{LeaderHint: rf.leaderId})
+		return p
+	case rf.transferTarget >= 0:
+		// leadership is about to move there.
+		p.resolve(&NotLeaderError{LeaderHint: rf.transferTarget})
+		return p
+	}
+
+	p.index = rf.appendCommand(command)
+	if p.index < 0 {
+		p.resolve(ErrStorageFault)
+		return p
+	}
+	rf.proposals[p.index] = p
+	return p
+}
+
+// proposalApplied resolves the proposal at index, if any, now that the
+// entry there was delivered with the given term.
+func (rf *Raft) proposalApplied(index int, term int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	p, ok := rf.proposals[index]
+	if !ok {
+		return
+	}
+	delete(rf.proposals, index)
+	if p.term != term {
+		// another leader's entry took our slot.
+		p.resolve(ErrLeadershipLost)
+		return
+	}
+	p.resolve(nil)
+}
+
+// failProposals fails every outstanding proposal, e.g. after stepping down.
+// Expects rf.mu to be locked.
+func (rf *Raft) failProposals(err error) {
+	for index, p := range rf.proposals {
+		p.resolve(err)
+		delete(rf.proposals, index)
+	}
+}
@@ -0,0 +1,116 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// resolve steps the cluster until p resolved.
+func (c *testCluster) resolve(tb testing.TB, p *Proposal) {
+	tb.Helper()
+	c.runUntil(tb, "the proposal to resolve", func() bool {
+		select {
+		case <-p.Done():
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestProposeReportsCommit(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	term := leader.Inspect().Term
+
+	p := leader.Propose(context.Background(), "x")
+	c.resolve(t, p)
+	index, gotTerm, err := p.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != p.Index() || gotTerm != term {
+		t.Errorf("applied at %d in term %d, want %d in %d", index, gotTerm, p.Index(), term)
+	}
+	if entry := leader.Inspect().Entries[index]; entry.Command != "x" || entry.Term != term {
+		t.Errorf("log holds %v at %d", entry, index)
+	}
+}
+
+func TestProposeRefused(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+	// the follower learns who leads from the first heartbeat.
+	c.run(c.cfg.HeartbeatInterval + c.cfg.TickInterval)
+
+	p := follower.Propose(context.Background(), "x")
+	_, _, err := p.Wait()
+	var notLeader *NotLeaderError
+	if !errors.As(err, &notLeader) || !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Propose on a follower: got %v, want a NotLeaderError", err)
+	}
+	if notLeader.LeaderHint != leader.serverId || p.Index() != -1 {
+		t.Errorf("hint %d at index %d, want %d at -1", notLeader.LeaderHint, p.Index(), leader.serverId)
+	}
+
+	// a leader handing off leadership points at its successor.
+	leader.rf.mu.Lock()
+	leader.rf.transferTarget = follower.serverId
+	leader.rf.mu.Unlock()
+	_, _, err = leader.Propose(context.Background(), "x").Wait()
+	if !errors.As(err, &notLeader) || notLeader.LeaderHint != follower.serverId {
+		t.Errorf("Propose during a transfer: got %v, want a hint to %d", err, follower.serverId)
+	}
+}
+
+func TestProposeFailsWhenLeadershipLost(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	old := c.settledLeader(t)
+	c.isolate(old)
+	p := old.Propose(context.Background(), "lost")
+
+	// the old leader steps down, whether CheckQuorum gets there first or
+	// the new leader's term once it rejoins.
+	c.runUntil(t, "a new leader", func() bool {
+		next := c.currentLeader()
+		return next != nil && next != old
+	})
+	c.connect(t, old)
+	c.resolve(t, p)
+	if _, _, err := p.Wait(); !errors.Is(err, ErrLeadershipLost) {
+		t.Errorf("got %v, want ErrLeadershipLost", err)
+	}
+}
+
+func TestProposeFailsOnStoppedNode(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	c.isolate(leader)
+	pending := leader.Propose(context.Background(), "pending")
+	leader.Stop()
+
+	if _, _, err := pending.Wait(); !errors.Is(err, ErrStopped) {
+		t.Errorf("proposal pending on Stop: got %v, want ErrStopped", err)
+	}
+	if _, _, err := leader.Propose(context.Background(), "x").Wait(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Propose after Stop: got %v, want ErrStopped", err)
+	}
+}
+
+func TestProposeWaitTimesOut(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	c.isolate(leader)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := leader.Propose(ctx, "x")
+	cancel()
+	if _, _, err := p.Wait(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+	if p.Index() < 0 {
+		t.Error("entry never appended")
+	}
+}
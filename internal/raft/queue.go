@@ -0,0 +1,80 @@
+// MESSAGE QUEUE
+// Under virtual time a queued ChannelNetwork holds every call and reply in
+// flight in one queue, ordered by when it is due and then by when it was
+// sent, and hands them over only when Deliver is called. Handed over that
+// way, from one goroutine, the same seed gets the same messages to the same
+// nodes in the same order on every run.
+package raft
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type messageQueue struct {
+	mu       sync.Mutex
+	clock    Clock
+	seq      uint64
+	messages []*queuedMessage // by due, then seq
+}
+
+type queuedMessage struct {
+	due time.Time
+	seq uint64
+	// deliver hands the message over; it returns false to hold it back
+	// until the next Deliver, as calls to a paused node are.
+	deliver func() bool
+}
+
+func compareMessages(a, b *queuedMessage) int {
+	if c := a.due.Compare(b.due); c != 0 {
+		return c
+	}
+	switch {
+	case a.seq < b.seq:
+		return -1
+	case a.seq > b.seq:
+		return 1
+	}
+	return 0
+}
+
+// push queues deliver to run once delay has passed on the queue's clock.
+func (q *messageQueue) push(delay time.Duration, deliver func() bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	q.insert(&queuedMessage{due: q.clock.Now().Add(delay), seq: q.seq, deliver: deliver})
+}
+
+// insert expects q.mu to be locked.
+func (q *messageQueue) insert(m *queuedMessage) {
+	i, _ := slices.BinarySearchFunc(q.messages, m, compareMessages)
+	q.messages = slices.Insert(q.messages, i, m)
+}
+
+// deliver hands over every message due by now in order, including those
+// that the deliveries themselves queue due already. Messages held back keep
+// their place for the next call. The deliveries run with q.mu unlocked, so
+// they may queue more.
+func (q *messageQueue) deliver() {
+	var held []*queuedMessage
+	for {
+		q.mu.Lock()
+		if len(q.messages) == 0 || q.messages[0].due.After(q.clock.Now()) {
+			for _, m := range held {
+				q.insert(m)
+			}
+			q.mu.Unlock()
+			return
+		}
+		m := q.messages[0]
+		q.messages = q.messages[1:]
+		q.mu.Unlock()
+
+		if !m.deliver() {
+			held = append(held, m)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package raft
 
 import (
+	"encoding/gob"
+	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/pro0o/raft-in-motion/internal/client"
 
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 )
 
 const DebugRF = 1
@@ -18,6 +21,8 @@ const (
 	Candidate
 	Leader
 	Dead
+	Learner
+	Faulted
 )
 
 func (s RfState) String() string {
@@ -30,6 +35,10 @@ func (s RfState) String() string {
 		return "Leader"
 	case Dead:
 		return "Dead"
+	case Learner:
+		return "Learner"
+	case Faulted:
+		return "Faulted"
 	default:
 		panic("unreachable")
 	}
@@ -39,6 +48,16 @@ type CommitEntry struct {
 	Command any
 	Index   int
 	Term    int
+
+	// Snapshot is non-nil when this entry carries a state-machine snapshot
+	// (installed from the leader or restored from storage) instead of a
+	// command. Index and Term then describe the last entry it covers.
+	Snapshot []byte
+
+	// LeaderChange is set for the no-op a leader appends when it is elected,
+	// with Term being its term. Command is nil; the application only needs to
+	// count Index as applied.
+	LeaderChange bool
 }
 
 type LogEntry struct {
@@ -46,17 +65,49 @@ type LogEntry struct {
 	Term    int
 }
 
+// NoOpEntry is the log command a new leader appends on election. Committing
+// it also commits every entry left over from earlier terms (section 5.4.2 of
+// the Raft paper), without waiting for a client to submit something.
+type NoOpEntry struct {
+	LeaderId int
+}
+
+func init() {
+	gob.Register(NoOpEntry{})
+}
+
 type Raft struct {
 	mu      sync.Mutex
 	id      int   // server ID
-	peerIds []int // IDs of all other peers
+	peerIds []int // IDs of all other voters in the current configuration
+
+	// Cluster membership: config and learners are the latest voter and
+	// learner sets found in the log, introduced at configIndex. baseConfig is
+	// the configuration covered by the snapshot (or the initial one) and is
+	// what they fall back to.
+	config      []int
+	learners    []int
+	configIndex int
+	baseConfig  ConfigEntry
 
 	server *Server // The Server that hosts this Raft instance (and handles RPC calls).
+	cfg    Config  // timings for this node, overrides already applied
+	clock  Clock
+	rand   *rand.Rand
+	logger *zerolog.Logger // where events go, cfg.Logger or the global one
 
 	// Persistent state on all servers
-	currentTerm int
-	votedFor    int
-	log         []LogEntry
+	currentTerm     int
+	votedFor        int
+	log             []LogEntry
+	storedHardState hardState // currentTerm and votedFor as last stored
+
+	// Log compaction: log[0] sits at index snapshotIndex+1, everything up to
+	// and including snapshotIndex lives in snapshot.
+	snapshotIndex   int
+	snapshotTerm    int
+	snapshot        []byte
+	snapshotPending bool // snapshot must be delivered on commitChan
 
 	// Volatile state on all servers
 	commitIndex int
@@ -65,12 +116,37 @@ type Raft struct {
 	// Volatile state on the leader
 	state              RfState
 	electionResetEvent time.Time
+	lastLeaderContact  time.Time     // last AppendEntries/InstallSnapshot from a current leader
+	faultErr           error         // storage error that took this node to Faulted
+	resumed            chan struct{} // closed when the current pause ends, nil if not paused
+	pausedUntil        time.Time     // when Tick ends the pause of a TickDriven node
+	leaderId           int           // leader of currentTerm as far as we know, -1 if unknown
+	preVote            bool          // run a PreVote round before every election
+	transferTarget     int           // peer leadership is being handed to, -1 if none
+	leaseRead          bool          // serve LeaseRead while the leader lease holds
+	maxClockDrift      float64       // assumed bound on clock rate differences, shortens the lease
+	leaseTimeout       time.Duration // shortest ElectionTimeoutMin in the cluster, the lease length before drift
 	nextIndex          map[int]int
 	matchIndex         map[int]int
+	lastContact        map[int]time.Time // last successful AppendEntries/InstallSnapshot reply per peer
+	heartbeatRound     int               // bumped on every leaderSendHeartbeats call
+	ackedRound         map[int]int       // latest heartbeat round each peer acked in our term
+	ackSentAt          map[int]time.Time // send time of the latest heartbeat each peer acked
+	pendingReads       []*readRequest    // ReadIndex calls waiting for a heartbeat quorum
+	proposals          map[int]*Proposal // Propose calls waiting for their entry, by log index
+
+	// TickDriven only: the single election timer Tick checks, armed in
+	// timerTerm with timerTimeout, and when the leader last sent heartbeats.
+	timerRunning  bool
+	timerTerm     int
+	timerTimeout  time.Duration
+	lastHeartbeat time.Time
+	ready         <-chan any // nil once the node started
 
 	// Communication channels
 	commitChan         chan<- CommitEntry // Channel for delivering committed entries to the client
 	newCommitReadyChan chan struct{}      // Internal notification channel when new commits are ready
+	commitSenderDone   chan struct{}      // closed once commitChanSender has sent its last entry
 
 	// Persist state
 	storage Storage
@@ -84,13 +160,33 @@ type Raft struct {
 
 func (rf *Raft) lastLogIndexAndTerm() (int, int) {
 	if len(rf.log) > 0 {
-		lastIndex := len(rf.log) - 1
-		return lastIndex, rf.log[lastIndex].Term
+		lastIndex := rf.logLen() - 1
+		return lastIndex, rf.log[len(rf.log)-1].Term
 	} else {
-		return -1, -1
+		return rf.snapshotIndex, rf.snapshotTerm
 	}
 }
 
+// logLen returns the length of the log counting the compacted prefix, i.e.
+// the index the next appended entry will get.
+func (rf *Raft) logLen() int {
+	return rf.snapshotIndex + 1 + len(rf.log)
+}
+
+// logPos maps a log index to its position in rf.log.
+func (rf *Raft) logPos(index int) int {
+	return index - rf.snapshotIndex - 1
+}
+
+// termAt returns the term of the entry at index, which must be either
+// snapshotIndex or still present in rf.log.
+func (rf *Raft) termAt(index int) int {
+	if index == rf.snapshotIndex {
+		return rf.snapshotTerm
+	}
+	return rf.log[rf.logPos(index)].Term
+}
+
 func (rf *Raft) Report() (id int, term int, isLeader bool) {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
@@ -98,18 +194,27 @@ func (rf *Raft) Report() (id int, term int, isLeader bool) {
 }
 
 func (rf *Raft) Submit(command any) int {
+	rf.waitResumed()
 	rf.mu.Lock()
-	if rf.state != Leader {
-		rf.mu.Unlock()
+	defer rf.mu.Unlock()
+	if rf.state != Leader || rf.transferTarget >= 0 {
 		return -1
 	}
-	submitIndex := len(rf.log)
-	rf.log = append(rf.log, LogEntry{Command: command, Term: rf.currentTerm})
-	rf.persistToStorage()
-
-	rf.mu.Unlock()
+	return rf.appendCommand(command)
+}
 
-	rf.triggerAEChan <- struct{}{}
+// appendCommand appends command to the leader's log, persists it and kicks
+// off replication, returning its index, or -1 if the node faulted persisting
+// it. Expects rf.mu to be locked.
+func (rf *Raft) appendCommand(command any) int {
+	submitIndex := rf.logLen()
+	rf.log = append(rf.log, LogEntry{Command: command, Term: rf.currentTerm})
+	if err := rf.persist(rf.stageEntries(submitIndex)); err != nil {
+		rf.fault(err)
+		return -1
+	}
+	rf.triggerAE()
+	rf.advanceCommitIndex()
 	return submitIndex
 }
 
@@ -120,125 +225,307 @@ func (rf *Raft) Kill() {
 		rf.mu.Unlock()
 		return
 	}
-	log.Info().
+	rf.logger.Info().
 		Int("raftID", rf.id).
 		Str("oldState", rf.state.String()).
 		Str("newState", Dead.String()).
 		Msg("stateTransition")
 
 	rf.state = Dead
+	rf.endPause()
+	rf.failPendingReads(ErrStopped)
+	rf.failProposals(ErrStopped)
 
 	close(rf.newCommitReadyChan)
 	close(rf.triggerAEChan)
 
 	rf.mu.Unlock()
 
+	// the owner of commitChan may close it once Kill returns.
+	<-rf.commitSenderDone
 }
 
 // Make initializes a Raft instance. The `ready` channel is used to signal
 // when the node should start its background processes (like the election timer).
+// A joining node starts outside the configuration formed by peerIds and only
+// becomes a voter once the leader replicates a ConfigEntry that includes it.
+// cfg is expected to pass Validate, which NewServer makes sure of.
 func Make(
 	id int,
 	peerIds []int,
+	joining bool,
 	server *Server,
 	storage Storage,
 	ready <-chan any,
 	commitChan chan<- CommitEntry,
+	cfg Config,
 	c *client.Client,
 ) *Raft {
 	rf := new(Raft)
 	rf.id = id
-	rf.peerIds = peerIds
+	rf.cfg = cfg.ForNode(id)
+	rf.leaseTimeout = cfg.MinElectionTimeout()
+	rf.logger = rf.cfg.logger()
+	rf.clock = rf.cfg.Clock
+	if rf.clock == nil {
+		rf.clock = realClock{}
+	}
+	rf.rand = newRand(rf.cfg.Seed, id)
+	rf.baseConfig.Voters = slices.Clone(peerIds)
+	if !joining {
+		rf.baseConfig.Voters = append(rf.baseConfig.Voters, id)
+	}
+	slices.Sort(rf.baseConfig.Voters)
 	rf.server = server
 	rf.storage = storage
 	rf.commitChan = commitChan
 	rf.newCommitReadyChan = make(chan struct{}, 16)
+	rf.commitSenderDone = make(chan struct{})
 	rf.triggerAEChan = make(chan struct{}, 1)
 	rf.state = Follower
 	rf.votedFor = -1
 	rf.commitIndex = -1
 	rf.lastApplied = -1
+	rf.snapshotIndex = -1
+	rf.snapshotTerm = -1
+	rf.transferTarget = -1
+	rf.leaderId = -1
+	rf.proposals = make(map[int]*Proposal)
 	rf.nextIndex = make(map[int]int)
 	rf.matchIndex = make(map[int]int)
+	rf.lastContact = make(map[int]time.Time)
+	rf.ackedRound = make(map[int]int)
+	rf.ackSentAt = make(map[int]time.Time)
 	rf.client = c
 
 	if rf.storage.HasData() {
-		rf.restoreFromStorage()
+		if err := rf.restoreFromStorage(); err != nil {
+			// come up Faulted: the rest of the cluster carries on without us.
+			rf.log = nil
+			rf.snapshot = nil
+			rf.fault(err)
+		}
+	}
+	rf.storedHardState = hardState{term: rf.currentTerm, votedFor: rf.votedFor}
+	rf.applyConfig()
+	if rf.snapshot != nil {
+		// hand the restored snapshot to the application before any entry.
+		rf.commitIndex = rf.snapshotIndex
+		rf.snapshotPending = true
+		rf.commitReady()
+	}
+	if rf.cfg.TickDriven {
+		// the first Tick after ready arms the timer, in tick order.
+		rf.ready = ready
+	} else {
+		go func() {
+			<-ready
+			rf.mu.Lock()
+			rf.electionResetEvent = rf.clock.Now()
+			rf.startElectionTimer()
+			rf.mu.Unlock()
+		}()
 	}
-	go func() {
-		<-ready
-		rf.mu.Lock()
-		rf.electionResetEvent = time.Now()
-		rf.mu.Unlock()
-		rf.runElectionTimer()
-	}()
 
 	go rf.commitChanSender()
 	return rf
 }
 
 func (rf *Raft) becomeFollower(term int) {
-	log.Info().
+	rf.becomeFollowerWithReason(term, "")
+}
+
+// becomeFollowerWithReason is becomeFollower with a reason attached to the
+// stateTransition event, for step-downs the UI should be able to explain.
+// Learners step down to Learner instead, since they never campaign.
+func (rf *Raft) becomeFollowerWithReason(term int, reason string) {
+	newState := Follower
+	if rf.isLearner() {
+		newState = Learner
+	}
+	ev := rf.logger.Info().
 		Int("raftID", rf.id).
 		Str("oldState", rf.state.String()).
-		Str("newState", Follower.String()).
-		Msg("stateTransition")
+		Str("newState", newState.String())
+	if reason != "" {
+		ev = ev.Str("reason", reason)
+	}
+	ev.Msg("stateTransition")
 
-	rf.state = Follower
-	rf.currentTerm = term
-	rf.votedFor = -1
-	rf.electionResetEvent = time.Now()
+	if rf.state == Leader {
+		rf.failPendingReads(ErrLeadershipLost)
+		rf.failProposals(ErrLeadershipLost)
+		rf.leaderId = -1
+	}
+	rf.state = newState
+	if term > rf.currentTerm {
+		// keep our vote when stepping down within the same term.
+		rf.votedFor = -1
+		rf.leaderId = -1
+		rf.currentTerm = term
+		if err := rf.persist(rf.stageHardState); err != nil {
+			rf.fault(err)
+			return
+		}
+	}
+	rf.electionResetEvent = rf.clock.Now()
 
-	go rf.runElectionTimer()
+	if newState == Follower {
+		rf.startElectionTimer()
+	}
 }
 
 func (rf *Raft) startLeader() {
 	rf.state = Leader
-	for _, peerId := range rf.peerIds {
-		rf.nextIndex[peerId] = len(rf.log)
+	rf.leaderId = rf.id
+	for _, peerId := range rf.replicaIds() {
+		rf.nextIndex[peerId] = rf.logLen()
 		rf.matchIndex[peerId] = -1
+		rf.lastContact[peerId] = rf.clock.Now()
+		rf.ackedRound[peerId] = 0
+		rf.ackSentAt[peerId] = time.Time{}
 	}
-	log.Info().
+	rf.logger.Info().
 		Int("raftID", rf.id).
 		Str("oldState", Candidate.String()).
 		Str("newState", rf.state.String()).
 		Msg("stateTransition")
 
+	// nextIndex is set before the no-op goes in, so the first round of
+	// heartbeats already carries it.
+	rf.log = append(rf.log, LogEntry{Command: NoOpEntry{LeaderId: rf.id}, Term: rf.currentTerm})
+	if err := rf.persist(rf.stageEntries(rf.logLen() - 1)); err != nil {
+		rf.fault(err)
+		return
+	}
+	if rf.cfg.Observer != nil {
+		rf.cfg.Observer.LeaderElected(rf.id, rf.currentTerm, rf.logView())
+	}
+	rf.advanceCommitIndex()
+
+	if rf.cfg.TickDriven {
+		// the next Tick sends the first round of heartbeats.
+		rf.lastHeartbeat = time.Time{}
+		rf.triggerAE()
+		return
+	}
+
 	go func(heartbeatTimeout time.Duration) {
 		rf.leaderSendHeartbeats()
 
-		t := time.NewTimer(heartbeatTimeout)
-		defer t.Stop()
-
+		timeout := rf.clock.After(heartbeatTimeout)
 		for {
-			doSend := false
 			select {
-			case <-t.C:
-				doSend = true
-				t.Stop()
-				t.Reset(heartbeatTimeout)
-
+			case <-timeout:
 			case _, ok := <-rf.triggerAEChan:
 				if !ok {
 					return
 				}
-				doSend = true
-
-				if !t.Stop() {
-					<-t.C
-				}
-				t.Reset(heartbeatTimeout)
 			}
+			timeout = rf.clock.After(heartbeatTimeout)
+			rf.waitResumed()
 
-			if doSend {
-				rf.mu.Lock()
-				if rf.state != Leader {
-					rf.mu.Unlock()
-					return
-				}
+			rf.mu.Lock()
+			if !rf.checkQuorum() {
 				rf.mu.Unlock()
-				rf.leaderSendHeartbeats()
+				return
 			}
+			rf.mu.Unlock()
+			rf.leaderSendHeartbeats()
+		}
+	}(rf.cfg.HeartbeatInterval)
+}
+
+// checkQuorum reports whether this node should keep acting as leader, and
+// steps it down if it lost contact with a majority. Expects rf.mu to be locked.
+func (rf *Raft) checkQuorum() bool {
+	if rf.state != Leader {
+		return false
+	}
+	if !rf.hasQuorumContact() {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", rf.currentTerm).
+			Msg("checkQuorumFailed")
+		rf.becomeFollowerWithReason(rf.currentTerm, "checkQuorum")
+		return false
+	}
+	return true
+}
+
+// triggerAE asks the leader loop to send AppendEntries right away. Requests
+// coalesce: one already pending covers this one too.
+func (rf *Raft) triggerAE() {
+	select {
+	case rf.triggerAEChan <- struct{}{}:
+	default:
+	}
+}
+
+// commitReady wakes commitChanSender. It never blocks: a wake-up already
+// pending delivers everything committed by then, and the sender may be held
+// up by a pause while rf.mu is locked.
+func (rf *Raft) commitReady() {
+	select {
+	case rf.newCommitReadyChan <- struct{}{}:
+	default:
+	}
+}
+
+// Tick advances a TickDriven node by one step: it checks the election
+// timer and, on a leader, sends heartbeats when they are due or were asked
+// for. A paused node only checks whether its pause is over. The caller
+// decides how much time passes between ticks, typically by advancing a
+// shared VirtualClock by TickInterval first.
+func (rf *Raft) Tick() {
+	rf.mu.Lock()
+	if rf.state == Dead || !rf.cfg.TickDriven {
+		rf.mu.Unlock()
+		return
+	}
+	if rf.resumed != nil {
+		if rf.clock.Now().Before(rf.pausedUntil) {
+			rf.mu.Unlock()
+			return
+		}
+		rf.resume()
+	}
+	if rf.ready != nil {
+		select {
+		case <-rf.ready:
+			rf.ready = nil
+			rf.electionResetEvent = rf.clock.Now()
+			rf.startElectionTimer()
+		default:
+			rf.mu.Unlock()
+			return
+		}
+	}
+
+	if rf.timerRunning {
+		// a timer that fires re-arms itself through the election it starts.
+		rf.timerRunning = false
+		if !rf.electionTimerTick(rf.timerTerm, rf.timerTimeout) {
+			rf.timerRunning = true
+		}
+	}
+
+	send := false
+	if rf.state == Leader {
+		triggered := false
+		select {
+		case <-rf.triggerAEChan:
+			triggered = true
+		default:
+		}
+		if triggered || rf.since(rf.lastHeartbeat) >= rf.cfg.HeartbeatInterval {
+			send = rf.checkQuorum()
+			rf.lastHeartbeat = rf.clock.Now()
 		}
-	}(50 * time.Millisecond)
+	}
+	rf.mu.Unlock()
+
+	if send {
+		rf.leaderSendHeartbeats()
+	}
 }
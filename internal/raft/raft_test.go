@@ -0,0 +1,140 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFaultedNodeRejectsRPCs(t *testing.T) {
+	c := newTestCluster(t, 3, func(int) Storage { return NewMapStorage() })
+	leader := c.leader(t)
+	var node *Server
+	for _, s := range c.servers {
+		if s != leader {
+			node = s
+			break
+		}
+	}
+	rf := node.rf
+	rf.mu.Lock()
+	rf.fault(errors.New("disk gone"))
+	term := rf.currentTerm
+	rf.mu.Unlock()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"RequestVote", func() error {
+			return rf.RequestVote(RequestVoteArgs{Term: term + 1, CandidateId: leader.serverId, LastLogIndex: 100, LastLogTerm: term}, &RequestVoteReply{})
+		}},
+		{"PreVote", func() error {
+			return rf.PreVote(PreVoteArgs{Term: term + 1, CandidateId: leader.serverId, LastLogIndex: 100, LastLogTerm: term}, &PreVoteReply{})
+		}},
+		{"AppendEntries", func() error {
+			return rf.AppendEntries(AppendEntriesArgs{Term: term, LeaderId: leader.serverId, PrevLogIndex: -1, LeaderCommit: -1}, &AppendEntriesReply{})
+		}},
+		{"InstallSnapshot", func() error {
+			return rf.InstallSnapshot(InstallSnapshotArgs{Term: term, LeaderId: leader.serverId, LastIncludedIndex: 0, LastIncludedTerm: term}, &InstallSnapshotReply{})
+		}},
+		{"TimeoutNow", func() error {
+			return rf.TimeoutNow(TimeoutNowArgs{Term: term, LeaderId: leader.serverId}, &TimeoutNowReply{})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrStorageFault) {
+				t.Errorf("got %v, want ErrStorageFault", err)
+			}
+		})
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.state != Faulted || rf.currentTerm != term {
+		t.Errorf("node is %v in term %d after the RPCs, want Faulted in %d", rf.state, rf.currentTerm, term)
+	}
+}
+
+func TestNewServerRejectsInvalidConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HeartbeatInterval = cfg.ElectionTimeoutMin
+	s, err := NewServer(0, []int{1, 2}, NewMapStorage(), make(chan any), make(chan CommitEntry), cfg, nil)
+	if !errors.Is(err, ErrInvalidConfig) || s != nil {
+		t.Errorf("got server %v, %v, want ErrInvalidConfig", s, err)
+	}
+}
+
+func TestCheckQuorumStepsDownIsolatedLeader(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	term := leader.Inspect().Term
+	pending := leader.Propose(context.Background(), "unreplicated")
+
+	c.isolate(leader)
+	c.run(c.cfg.ElectionTimeoutMax + c.cfg.HeartbeatInterval)
+	leader.rf.mu.Lock()
+	state, leaderTerm := leader.rf.state, leader.rf.currentTerm
+	leader.rf.mu.Unlock()
+	if state != Follower {
+		t.Fatalf("isolated leader is %v after an election timeout, want Follower", state)
+	}
+	// stepping down on its own starts no new term.
+	if leaderTerm != term {
+		t.Errorf("isolated leader moved to term %d from %d", leaderTerm, term)
+	}
+	if _, _, err := pending.Wait(); !errors.Is(err, ErrLeadershipLost) {
+		t.Errorf("proposal pending on the deposed leader: got %v, want ErrLeadershipLost", err)
+	}
+}
+
+func TestCheckQuorumKeepsConnectedLeader(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	term := leader.Inspect().Term
+
+	// one follower gone still leaves a majority.
+	c.isolate(followerOf(c, leader))
+	c.run(5 * c.cfg.ElectionTimeoutMax)
+	if !leader.IsLeader() || leader.Inspect().Term != term {
+		t.Errorf("leader %d lost leadership with a majority still connected", leader.serverId)
+	}
+}
+
+func TestLeaderAppendsNoOp(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	view := leader.Inspect()
+	noop := view.Entries[view.CommitIndex]
+	if noop.Command != (NoOpEntry{LeaderId: leader.serverId}) || noop.Term != view.Term {
+		t.Fatalf("leader committed %+v, want its own no-op in term %d", noop, view.Term)
+	}
+	c.runUntil(t, "the followers to commit the no-op", func() bool {
+		for _, s := range c.servers {
+			if s.Inspect().CommitIndex < view.CommitIndex {
+				return false
+			}
+		}
+		return true
+	})
+
+	// an entry of an earlier term only commits on the next leader through
+	// a no-op of its own, and no client write is needed for that.
+	index := submitAll(t, leader, 1)
+	c.runUntil(t, "the entry to replicate", func() bool {
+		return len(followerOf(c, leader).Inspect().Entries) > index
+	})
+	c.isolate(leader)
+	var next *Server
+	c.runUntil(t, "a new leader to commit its no-op", func() bool {
+		next = c.currentLeader()
+		return next != nil && next != leader && next.Inspect().CommitIndex > index
+	})
+	view = next.Inspect()
+	if got := view.Entries[index]; got.Command != 0 {
+		t.Errorf("entry %d is %+v, want the old leader's", index, got)
+	}
+	if got := view.Entries[view.CommitIndex]; got.Command != (NoOpEntry{LeaderId: next.serverId}) || got.Term != view.Term {
+		t.Errorf("new leader committed %+v, want its own no-op in term %d", got, view.Term)
+	}
+}
@@ -0,0 +1,106 @@
+// READ INDEX
+// Linearizable reads without touching the log (section 6.4 of the Raft
+// thesis). The leader remembers its commitIndex, proves it is still leader
+// with one heartbeat round acked by a majority, and the application serves
+// the read once it has applied up to that index.
+package raft
+
+import (
+	"slices"
+	"time"
+)
+
+// readRequest is a ReadIndex call waiting for a majority to ack heartbeat
+// round (or later).
+type readRequest struct {
+	round     int
+	readIndex int
+	done      chan error
+}
+
+// ReadIndex returns an index the application must have applied before it
+// answers a read locally. It fails with ErrNotLeader on followers and with
+// ErrReadIndexNotReady while the leader has not committed an entry of its
+// own term yet, since its commitIndex may still lag behind its predecessor's.
+func (rf *Raft) ReadIndex() (int, error) {
+	rf.waitResumed()
+	rf.mu.Lock()
+	if rf.state != Leader {
+		rf.mu.Unlock()
+		return -1, ErrNotLeader
+	}
+	if rf.commitIndex < 0 || rf.termAt(rf.commitIndex) != rf.currentTerm {
+		rf.mu.Unlock()
+		return -1, ErrReadIndexNotReady
+	}
+
+	req := &readRequest{
+		round:     rf.heartbeatRound + 1,
+		readIndex: rf.commitIndex,
+		done:      make(chan error, 1),
+	}
+	rf.pendingReads = append(rf.pendingReads, req)
+	rf.resolveReads()
+	rf.triggerAE()
+	rf.mu.Unlock()
+
+	select {
+	case err := <-req.done:
+		if err != nil {
+			return -1, err
+		}
+	case <-rf.clock.After(rf.cfg.ElectionTimeoutMax):
+		rf.mu.Lock()
+		rf.pendingReads = slices.DeleteFunc(rf.pendingReads, func(r *readRequest) bool { return r == req })
+		rf.mu.Unlock()
+		return -1, ErrTimeout
+	}
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("readIndex", req.readIndex).
+		Msg("readIndexConfirmed")
+	return req.readIndex, nil
+}
+
+// recordAck notes that peerId accepted us as leader in heartbeat round,
+// which was sent at sentAt. Expects rf.mu to be locked.
+func (rf *Raft) recordAck(peerId int, round int, sentAt time.Time) {
+	if round > rf.ackedRound[peerId] {
+		rf.ackedRound[peerId] = round
+	}
+	if sentAt.After(rf.ackSentAt[peerId]) {
+		rf.ackSentAt[peerId] = sentAt
+	}
+	rf.resolveReads()
+}
+
+// resolveReads completes every pending read whose round a majority acked.
+// Expects rf.mu to be locked.
+func (rf *Raft) resolveReads() {
+	rf.pendingReads = slices.DeleteFunc(rf.pendingReads, func(req *readRequest) bool {
+		acks := 0
+		if rf.isVoter() {
+			acks++
+		}
+		for _, peerId := range rf.peerIds {
+			if rf.ackedRound[peerId] >= req.round {
+				acks++
+			}
+		}
+		if rf.quorum(acks) {
+			req.done <- nil
+			return true
+		}
+		return false
+	})
+}
+
+// failPendingReads aborts every waiting read, e.g. after stepping down.
+// Expects rf.mu to be locked.
+func (rf *Raft) failPendingReads(err error) {
+	for _, req := range rf.pendingReads {
+		req.done <- err
+	}
+	rf.pendingReads = nil
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadIndex(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	index := submitAll(t, leader, 3)
+	c.runUntil(t, "the entries to commit", func() bool {
+		return leader.Inspect().CommitIndex >= index
+	})
+
+	var got int
+	var err error
+	c.await(t, func() { got, err = leader.ReadIndex() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != index {
+		t.Errorf("read index %d, want the commit index %d", got, index)
+	}
+
+	if _, err := followerOf(c, leader).ReadIndex(); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("ReadIndex on a follower: got %v, want ErrNotLeader", err)
+	}
+}
+
+func TestReadIndexWaitsForLeaderNoop(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	for _, s := range c.servers {
+		c.isolate(s)
+	}
+
+	// the leader as it is right after winning the next term: its no-op is
+	// in the log but not committed yet.
+	rf := leader.rf
+	rf.mu.Lock()
+	rf.currentTerm++
+	rf.log = append(rf.log, LogEntry{Command: NoOpEntry{LeaderId: rf.id}, Term: rf.currentTerm})
+	rf.mu.Unlock()
+
+	if _, err := leader.ReadIndex(); !errors.Is(err, ErrReadIndexNotReady) {
+		t.Errorf("got %v, want ErrReadIndexNotReady", err)
+	}
+}
+
+func TestReadIndexFailsWithoutQuorum(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	c.isolate(leader)
+
+	// no majority acks the heartbeat round: the read times out or the
+	// leader steps down first, but it is never confirmed.
+	var err error
+	c.await(t, func() { _, err = leader.ReadIndex() })
+	if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrLeadershipLost) {
+		t.Errorf("got %v, want ErrTimeout or ErrLeadershipLost", err)
+	}
+}
@@ -4,7 +4,7 @@
 package raft
 
 import (
-	"time"
+	"slices"
 )
 
 type RequestVoteArgs struct {
@@ -12,6 +12,10 @@ type RequestVoteArgs struct {
 	CandidateId  int
 	LastLogIndex int
 	LastLogTerm  int
+
+	// LeadershipTransfer marks elections started by TimeoutNow; the old
+	// leader asked for them, so recent contact with it is no reason to refuse.
+	LeadershipTransfer bool
 }
 
 type RequestVoteReply struct {
@@ -26,6 +30,27 @@ func (rf *Raft) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error
 	if rf.state == Dead {
 		return nil
 	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
+
+	// A server removed from the configuration stops getting heartbeats and
+	// will time out; don't let its inflated term depose the cluster.
+	if !slices.Contains(rf.config, args.CandidateId) {
+		reply.Term = rf.currentTerm
+		reply.VoteGranted = false
+		return nil
+	}
+
+	// Still hearing from a leader: refuse without adopting the term. Besides
+	// stopping needless elections this is what makes leader leases safe, no
+	// one can be elected while the leader's lease may still be running.
+	if !args.LeadershipTransfer && rf.state == Follower &&
+		rf.since(rf.lastLeaderContact) < rf.cfg.ElectionTimeoutMin {
+		reply.Term = rf.currentTerm
+		reply.VoteGranted = false
+		return nil
+	}
 
 	localLastIndex, localLastTerm := rf.lastLogIndexAndTerm()
 
@@ -40,14 +65,19 @@ func (rf *Raft) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error
 			(args.LastLogTerm == localLastTerm && args.LastLogIndex >= localLastIndex)) {
 		reply.VoteGranted = true
 		rf.votedFor = args.CandidateId
-		rf.electionResetEvent = time.Now()
+		rf.electionResetEvent = rf.clock.Now()
 
 	} else {
 	}
 
 	reply.Term = rf.currentTerm
 
-	rf.persistToStorage()
-
+	if err := rf.persist(rf.stageHardState); err != nil {
+		rf.fault(err)
+	}
+	if rf.state == Faulted {
+		// a vote we could not persist must not count.
+		return ErrStorageFault
+	}
 	return nil
 }
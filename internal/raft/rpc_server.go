@@ -1,17 +1,15 @@
 package raft
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"net"
-	"net/rpc"
 	"os"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/pro0o/raft-in-motion/internal/client"
-
-	"github.com/rs/zerolog/log"
 )
 
 type Server struct {
@@ -20,184 +18,234 @@ type Server struct {
 	serverId int
 	peerIds  []int
 
-	rf       *Raft
-	storage  Storage
-	rpcProxy *RPCProxy
-
-	rpcServer *rpc.Server
-	listener  net.Listener
+	rf        *Raft
+	storage   Storage
+	rpcProxy  *RPCProxy
+	transport Transport
 
-	commitChan  chan<- CommitEntry
-	peerClients map[int]*rpc.Client
+	commitChan chan<- CommitEntry
 
 	ready  <-chan any
-	quit   chan any
-	wg     sync.WaitGroup
 	client *client.Client
 }
 
-func NewServer(serverId int, peerIds []int, storage Storage, ready <-chan any, commitChan chan<- CommitEntry, c *client.Client) *Server {
+// NewServer creates the server for node serverId, or fails with
+// ErrInvalidConfig if cfg's timings can't keep a leader in place.
+func NewServer(serverId int, peerIds []int, storage Storage, ready <-chan any, commitChan chan<- CommitEntry, cfg Config, c *client.Client) (*Server, error) {
+	return newServer(serverId, peerIds, false, storage, ready, commitChan, cfg, c)
+}
+
+// NewJoiningServer creates a server that is not part of the configuration
+// formed by peerIds yet; it waits to be added with AddVoter on the leader.
+func NewJoiningServer(serverId int, peerIds []int, storage Storage, ready <-chan any, commitChan chan<- CommitEntry, cfg Config, c *client.Client) (*Server, error) {
+	return newServer(serverId, peerIds, true, storage, ready, commitChan, cfg, c)
+}
+
+func newServer(serverId int, peerIds []int, joining bool, storage Storage, ready <-chan any, commitChan chan<- CommitEntry, cfg Config, c *client.Client) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	s := new(Server)
 	s.serverId = serverId
 	s.peerIds = peerIds
-	s.peerClients = make(map[int]*rpc.Client)
 	s.storage = storage
 	s.ready = ready
 	s.commitChan = commitChan
-	s.quit = make(chan any)
 	s.client = c
+	if cfg.NewTransport != nil {
+		s.transport = cfg.NewTransport(serverId)
+	} else {
+		s.transport = NewTCPTransport(serverId)
+	}
+	// the transports of this package log along with the node.
+	switch t := s.transport.(type) {
+	case *TCPTransport:
+		t.logger = cfg.logger()
+	case *ChannelTransport:
+		t.logger = cfg.logger()
+	}
 
 	defer func() {
 		s.mu.Lock()
 		s.mu.Unlock()
-		s.rf = Make(s.serverId, s.peerIds, s, s.storage, s.ready, s.commitChan, c)
+		s.rf = Make(s.serverId, s.peerIds, joining, s, s.storage, s.ready, s.commitChan, cfg, c)
 	}()
-	return s
+	return s, nil
 }
 
-// Serve starts the RPC server in a separate goroutine,
-// listening on an automatically assigned TCP port.
+// Serve starts taking RPCs from peers over the server's transport, in a
+// separate goroutine.
 func (s *Server) Serve() {
 	s.mu.Lock()
-	s.rpcServer = rpc.NewServer()
+	defer s.mu.Unlock()
 	s.rpcProxy = NewProxy(s.rf)
-	if err := s.rpcServer.RegisterName("Raft", s.rpcProxy); err != nil {
-		log.Error().Err(err).Int("serverId", s.serverId).Msg("Failed to register Raft RPC proxy")
+	if err := s.transport.Serve(s.rpcProxy); err != nil {
+		s.rf.logger.Error().Err(err).Int("serverId", s.serverId).Msg("Failed to serve Raft RPCs. Server shutting down.")
 	}
+}
 
-	var err error
-	s.listener, err = net.Listen("tcp", ":0")
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to start TCP listener. Server shutting down.")
-		s.mu.Unlock()
-		return
-	}
-	log.Info().
-		Int("raftID", s.serverId).
-		Str("address", s.listener.Addr().String()).
-		Msg("serverListening")
+func (s *Server) Submit(cmd any) int {
+	return s.rf.Submit(cmd)
+}
 
-	s.mu.Unlock()
+// Fault returns the storage error that faulted this server, or nil.
+func (s *Server) Fault() error {
+	return s.rf.Fault()
+}
 
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		for {
-			conn, err := s.listener.Accept()
-			if err != nil {
-				select {
-				case <-s.quit:
-					return
-				default:
-					log.Error().Err(err).Msg("Accept error while listening for RPC connections")
-				}
-			} else {
-				// log.Printf("Serve: Accepted new connection.")
-				s.wg.Add(1)
-				go func() {
-					s.rpcServer.ServeConn(conn)
-					s.wg.Done()
-				}()
-			}
-		}
-	}()
+// Propose appends cmd to the log if this server is the leader and returns a
+// future reporting whether and where it was applied; see Raft.Propose.
+func (s *Server) Propose(ctx context.Context, cmd any) *Proposal {
+	return s.rf.Propose(ctx, cmd)
 }
 
-func (s *Server) Submit(cmd any) int {
-	return s.rf.Submit(cmd)
+// Snapshot hands the application's state at index to Raft for log compaction.
+func (s *Server) Snapshot(index int, data []byte) {
+	s.rf.Snapshot(index, data)
+}
+
+// SetPreVote enables or disables the PreVote round on this server.
+func (s *Server) SetPreVote(enabled bool) {
+	s.rf.SetPreVote(enabled)
+}
+
+// TransferLeadership hands leadership to target (or the most up-to-date voter
+// if target is negative). Only works on the leader.
+func (s *Server) TransferLeadership(target int) error {
+	return s.rf.TransferLeadership(target)
+}
+
+// ReadIndex returns the index this server's state machine must reach before
+// serving a linearizable read locally. Only works on the leader.
+func (s *Server) ReadIndex() (int, error) {
+	return s.rf.ReadIndex()
+}
+
+// SetLeaseRead enables or disables leader lease reads on this server.
+func (s *Server) SetLeaseRead(enabled bool, maxClockDrift float64) {
+	s.rf.SetLeaseRead(enabled, maxClockDrift)
+}
+
+// LeaseRead returns the commit index if this server holds a valid leader
+// lease, so a read can be served locally without any network round trip.
+func (s *Server) LeaseRead() (int, error) {
+	return s.rf.LeaseRead()
+}
+
+// AddVoter asks this server, if leader, to add peerId to the cluster.
+func (s *Server) AddVoter(peerId int) (int, error) {
+	return s.rf.AddVoter(peerId)
+}
+
+// RemoveVoter asks this server, if leader, to remove peerId from the cluster.
+func (s *Server) RemoveVoter(peerId int) (int, error) {
+	return s.rf.RemoveVoter(peerId)
+}
+
+// AddLearner asks this server, if leader, to add peerId as a non-voting learner.
+func (s *Server) AddLearner(peerId int) (int, error) {
+	return s.rf.AddLearner(peerId)
+}
+
+// RemoveLearner asks this server, if leader, to drop the learner peerId.
+func (s *Server) RemoveLearner(peerId int) (int, error) {
+	return s.rf.RemoveLearner(peerId)
+}
+
+// PromoteLearner asks this server, if leader, to make the learner peerId a
+// voter; it fails with ErrLearnerBehind until the learner has caught up.
+func (s *Server) PromoteLearner(peerId int) (int, error) {
+	return s.rf.PromoteLearner(peerId)
+}
+
+// Pause freezes this server for d while it stays connected; see Raft.Pause.
+func (s *Server) Pause(d time.Duration) error {
+	return s.rf.Pause(d)
+}
+
+// Inspect returns a copy of this server's log; see Raft.Inspect.
+func (s *Server) Inspect() LogView {
+	return s.rf.Inspect()
+}
+
+// Tick advances a TickDriven server by one step; see Raft.Tick.
+func (s *Server) Tick() {
+	s.rf.Tick()
+}
+
+// Configuration returns the voter set this server is currently using.
+func (s *Server) Configuration() []int {
+	return s.rf.Configuration()
+}
+
+// Learners returns the learner set this server is currently using.
+func (s *Server) Learners() []int {
+	return s.rf.Learners()
 }
 
 func (s *Server) DisconnectAll() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	// log.Info().
-	// 	Int("raftID", s.serverId).
-	// 	Msg("disconnectionInitialized")
-
-	for id := range s.peerClients {
-		if s.peerClients[id] != nil {
-			_ = s.peerClients[id].Close() // ignoring close error
-			s.peerClients[id] = nil
-		}
-	}
-	log.Info().
-		Int("raftID", s.serverId).
-		Msg("disconnectionComplete")
+	s.transport.DisconnectAll()
 }
 
 func (s *Server) Shutdown() {
-	// log.Info().
-	// 	Int("raftID", s.serverId).
-	// 	Msg("shutdownInitialized")
+	// hand off leadership first so the cluster doesn't wait out an election.
+	if s.IsLeader() {
+		_ = s.rf.TransferLeadership(-1)
+	}
+	s.Stop()
+}
+
+// Stop shuts the server down without handing off leadership first, as a
+// crash would.
+func (s *Server) Stop() {
 	s.rf.Kill()
 
-	close(s.quit)
-	_ = s.listener.Close()
-	s.wg.Wait()
-	log.Info().
+	_ = s.transport.Close()
+	s.rf.logger.Info().
 		Int("raftID", s.serverId).
 		Msg("shutdownComplete")
 }
 
 func (s *Server) GetListenAddr() net.Addr {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	// log.Printf("GetListenAddr: Fetching listen address for serverId %d", s.serverId) // Debugging point
-	return s.listener.Addr()
+	return s.transport.Addr()
 }
 
 func (s *Server) ConnectToPeer(peerId int, addr net.Addr) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.peerClients[peerId] == nil {
-		client, err := rpc.Dial(addr.Network(), addr.String())
-		if err != nil {
-			log.Error().Err(err).Int("serverId", s.serverId).Int("peerId", peerId).Msg("Failed to connect to peer")
-			return err
-		}
-		s.peerClients[peerId] = client
-		log.Info().
-			Int("raftID", s.serverId).
-			Int("peer", peerId).
-			Str("address", addr.String()).
-			Msg("peerConnected")
-
-	}
-	return nil
+	return s.transport.Connect(peerId, addr)
 }
 
 // DisconnectPeer closes the connection to a specific peer.
 func (s *Server) DisconnectPeer(peerId int) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.peerClients[peerId] != nil {
-		err := s.peerClients[peerId].Close()
-		s.peerClients[peerId] = nil
-		if err != nil {
-			log.Error().Err(err).Int("peer", peerId).Msg("Failed to disconnect from peer")
-		} else {
-			log.Info().Int("peer", peerId).Msg("peerDisconnected")
-			// log.Printf("DisconnectPeer: Disconnected from peerId %d", peerId) // Debugging point
-		}
-		return err
-	}
-	return nil
+	return s.transport.Disconnect(peerId)
 }
 
 // Call invokes an RPC on the specified peer.
 func (s *Server) Call(id int, serviceMethod string, args any, reply any) error {
 	s.mu.Lock()
-	peer := s.peerClients[id]
+	proxy := s.rpcProxy
 	s.mu.Unlock()
+	return proxy.Call(s.transport, id, serviceMethod, args, reply)
+}
 
-	if peer == nil {
-		// log.Error().Int("clientId", id).Msg("Attempted to call peer RPC after client connection was closed")
-		return fmt.Errorf("call client %d after it's closed", id)
+// send invokes an RPC on the specified peer without waiting for it: done
+// gets the result once the reply is in. done never runs before send
+// returns, so callers may hold rf.mu across send and take it in done.
+func (s *Server) send(id int, serviceMethod string, args any, reply any, done func(error)) {
+	s.mu.Lock()
+	proxy := s.rpcProxy
+	s.mu.Unlock()
+	if t, ok := s.transport.(*ChannelTransport); ok && t.queued() {
+		if err := proxy.send(t, id, serviceMethod, args, reply, done); err != nil {
+			t.schedule(0, func() bool {
+				done(err)
+				return true
+			})
+		}
+		return
 	}
-	// log.Printf("Call: Calling method %s on peerId %d", serviceMethod, id) // Debugging point
-	return s.rpcProxy.Call(peer, serviceMethod, args, reply)
+	go func() {
+		done(proxy.Call(s.transport, id, serviceMethod, args, reply))
+	}()
 }
 
 // IsLeader returns true if this server's Raft instance is leader.
@@ -227,58 +275,166 @@ func NewProxy(rf *Raft) *RPCProxy {
 	}
 }
 
-func (rpp *RPCProxy) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+// holding reports whether calls to this node wait, as they do while it is
+// paused.
+func (rpp *RPCProxy) holding() bool {
+	return rpp.rf.paused()
+}
+
+// latency draws how long a call takes to reach this node, or fails the call
+// when the unreliable network set by RAFT_UNRELIABLE_RPC drops it.
+func (rpp *RPCProxy) latency() (time.Duration, error) {
 	if len(os.Getenv("RAFT_UNRELIABLE_RPC")) > 0 {
-		dice := rand.Intn(10)
+		dice := rpp.rf.rand.Intn(10)
 		switch dice {
 		case 9:
-			return fmt.Errorf("RPC dropped by proxy")
+			// Drop the RPC.
+			return 0, fmt.Errorf("RPC dropped by proxy")
 		case 8:
-			time.Sleep(75 * time.Millisecond)
+			// Delay the RPC.
+			return rpp.rf.cfg.UnreliableRPCDelay, nil
 		}
-	} else {
-		// Slight random delay to simulate network latency
-		delay := time.Duration(1+rand.Intn(5)) * time.Millisecond
-		time.Sleep(delay)
+		return 0, nil
+	}
+	// Slight random delay to simulate network latency
+	return rpp.rf.cfg.rpcDelay(rpp.rf.rand), nil
+}
+
+// receive holds an incoming call the way the network would: while this node
+// is paused and then for its latency. Calls over a queued network spent
+// both in the queue already.
+func (rpp *RPCProxy) receive() error {
+	if t, ok := rpp.rf.server.transport.(*ChannelTransport); ok && t.queued() {
+		return nil
+	}
+	rpp.rf.waitResumed()
+	delay, err := rpp.latency()
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		<-rpp.rf.clock.After(delay)
+	}
+	return nil
+}
+
+func (rpp *RPCProxy) RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error {
+	if err := rpp.receive(); err != nil {
+		return err
 	}
 	return rpp.rf.RequestVote(args, reply)
 }
 
 func (rpp *RPCProxy) AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error {
-	// log.Printf("AppendEntries: Simulating AppendEntries RPC") // Debugging point
-	if len(os.Getenv("RAFT_UNRELIABLE_RPC")) > 0 {
-		dice := rand.Intn(10)
-		switch dice {
-		case 9:
-			// Drop the RPC.
-			return fmt.Errorf("RPC dropped by proxy")
-		case 8:
-			// Delay the RPC.
-			time.Sleep(75 * time.Millisecond)
-		}
-	} else {
-		// Slight random delay to simulate network latency
-		delay := time.Duration(1+rand.Intn(5)) * time.Millisecond
-		time.Sleep(delay)
+	if err := rpp.receive(); err != nil {
+		return err
 	}
 	return rpp.rf.AppendEntries(args, reply)
 }
 
-// Call checks if we should drop the call or forward it to the peer's RPC client.
-func (rpp *RPCProxy) Call(peer *rpc.Client, method string, args any, reply any) error {
-	// log.Printf("RPCProxy Call: Calling %s method on peer", method) // Debugging point
+func (rpp *RPCProxy) PreVote(args PreVoteArgs, reply *PreVoteReply) error {
+	if err := rpp.receive(); err != nil {
+		return err
+	}
+	return rpp.rf.PreVote(args, reply)
+}
+
+func (rpp *RPCProxy) TimeoutNow(args TimeoutNowArgs, reply *TimeoutNowReply) error {
+	if err := rpp.receive(); err != nil {
+		return err
+	}
+	return rpp.rf.TimeoutNow(args, reply)
+}
+
+func (rpp *RPCProxy) InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	if err := rpp.receive(); err != nil {
+		return err
+	}
+	return rpp.rf.InstallSnapshot(args, reply)
+}
+
+// countCall fails the call once the calls DropCallsAfterN allowed are used
+// up.
+func (rpp *RPCProxy) countCall() error {
 	rpp.mu.Lock()
+	defer rpp.mu.Unlock()
 	if rpp.numCallsBeforeDrop == 0 {
-		rpp.mu.Unlock()
 		return fmt.Errorf("RPC forcibly dropped by proxy")
 	}
 	if rpp.numCallsBeforeDrop > 0 {
 		rpp.numCallsBeforeDrop--
 	}
-	rpp.mu.Unlock()
+	return nil
+}
+
+// Call checks if we should drop the call or forward it to the peer over t.
+// While the node is paused neither the call nor its reply gets through.
+func (rpp *RPCProxy) Call(t Transport, peerId int, method string, args any, reply any) error {
+	rpp.rf.waitResumed()
+	defer rpp.rf.waitResumed()
+	if ct, ok := t.(*ChannelTransport); ok && ct.queued() {
+		errc := make(chan error, 1)
+		if err := rpp.send(ct, peerId, method, args, reply, func(err error) { errc <- err }); err != nil {
+			return err
+		}
+		return <-errc
+	}
+	if err := rpp.countCall(); err != nil {
+		return err
+	}
 
 	// Forward the call to the peer if not dropped.
-	return peer.Call(method, args, reply)
+	if links := rpp.rf.cfg.Links; links != nil {
+		return rpp.callOverLinks(links, t, peerId, method, args, reply)
+	}
+	return t.Call(peerId, method, args, reply)
+}
+
+// send is Call over a queued network: it queues the call with the latency
+// and link faults it meets on the way, and done gets the result when the
+// reply is delivered, held back while this node is paused. It returns the
+// errors that keep the call from going out at all; done isn't run then.
+func (rpp *RPCProxy) send(t *ChannelTransport, peerId int, method string, args any, reply any, done func(error)) error {
+	if err := rpp.countCall(); err != nil {
+		return err
+	}
+	delay, err := rpp.latency()
+	if err != nil {
+		return err
+	}
+	id, clock, links := rpp.rf.id, rpp.rf.clock, rpp.rf.cfg.Links
+	duplicate := false
+	if links != nil {
+		drop, linkDelay, dup := links.send(id, peerId, clock.Now(), func() int { return encodedSize(args) })
+		if drop {
+			rpp.logLinkDropped(id, peerId, method, "request")
+			return fmt.Errorf("%s to %d: %w", method, peerId, ErrLinkDropped)
+		}
+		delay += linkDelay
+		duplicate = dup
+	}
+	if duplicate {
+		dup := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+		_ = t.post(peerId, method, args, dup, delay, func(error) {})
+	}
+	return t.post(peerId, method, args, reply, delay, func(err error) {
+		var back time.Duration
+		if err == nil && links != nil {
+			drop, linkDelay, _ := links.send(peerId, id, clock.Now(), func() int { return encodedSize(reply) })
+			if drop {
+				rpp.logLinkDropped(peerId, id, method, "reply")
+				err = fmt.Errorf("%s reply from %d: %w", method, peerId, ErrLinkDropped)
+			}
+			back = linkDelay
+		}
+		t.schedule(back, func() bool {
+			if rpp.holding() {
+				return false
+			}
+			done(err)
+			return true
+		})
+	})
 }
 
 // DropCallsAfterN configures the proxy to start dropping all calls after N more calls.
@@ -0,0 +1,159 @@
+// IS RPC
+// Log compaction and InstallSnapshot RPC from section 7 of the Raft paper.
+package raft
+
+type InstallSnapshotArgs struct {
+	Term     int
+	LeaderId int
+
+	LastIncludedIndex  int
+	LastIncludedTerm   int
+	LastIncludedConfig ConfigEntry
+	Data               []byte
+}
+
+type InstallSnapshotReply struct {
+	Term int
+}
+
+// Snapshot is called by the application once it has applied every entry up
+// to and including index; data is its state machine at that point. Raft drops
+// the covered log prefix and keeps data around for lagging followers.
+func (rf *Raft) Snapshot(index int, data []byte) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state == Dead || index <= rf.snapshotIndex || index > rf.lastApplied {
+		return
+	}
+
+	cfg, _ := rf.configAt(index)
+	rf.compactLog(index, rf.termAt(index), cfg, data)
+	if err := rf.persist(rf.stageSnapshot); err != nil {
+		rf.fault(err)
+		return
+	}
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Int("snapshotIndex", rf.snapshotIndex).
+		Int("logLength", len(rf.log)).
+		Msg("snapshotTaken")
+}
+
+// compactLog discards every entry up to and including index and records the
+// snapshot that replaces them, along with the configuration in effect at
+// index. Entries after index are kept only if the log still agrees with the
+// snapshot at index. Expects rf.mu to be locked.
+func (rf *Raft) compactLog(index int, term int, cfg ConfigEntry, data []byte) {
+	var tail []LogEntry
+	if index < rf.logLen() && rf.termAt(index) == term {
+		tail = make([]LogEntry, len(rf.log[rf.logPos(index+1):]))
+		copy(tail, rf.log[rf.logPos(index+1):])
+	}
+	rf.log = tail
+	rf.snapshotIndex = index
+	rf.snapshotTerm = term
+	rf.snapshot = data
+	rf.baseConfig = cfg
+	rf.applyConfig()
+}
+
+func (rf *Raft) InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state == Dead {
+		return nil
+	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
+
+	if args.Term > rf.currentTerm {
+		rf.becomeFollower(args.Term)
+	}
+
+	reply.Term = rf.currentTerm
+	if args.Term < rf.currentTerm {
+		return nil
+	}
+	if rf.state != Follower && rf.state != Learner {
+		rf.becomeFollower(args.Term)
+	}
+	rf.electionResetEvent = rf.clock.Now()
+	rf.lastLeaderContact = rf.electionResetEvent
+	rf.leaderId = args.LeaderId
+
+	// already have everything the snapshot covers.
+	if args.LastIncludedIndex <= rf.commitIndex {
+		if err := rf.persist(rf.stageHardState); err != nil {
+			rf.fault(err)
+		}
+		if rf.state == Faulted {
+			return ErrStorageFault
+		}
+		return nil
+	}
+
+	rf.compactLog(args.LastIncludedIndex, args.LastIncludedTerm, args.LastIncludedConfig, args.Data)
+	rf.commitIndex = args.LastIncludedIndex
+	rf.snapshotPending = true
+	if err := rf.persist(rf.stageHardState, rf.stageSnapshot); err != nil {
+		rf.fault(err)
+		return ErrStorageFault
+	}
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Int("peer", args.LeaderId).
+		Int("snapshotIndex", args.LastIncludedIndex).
+		Msg("snapshotInstalled")
+
+	rf.commitReady()
+	return nil
+}
+
+// leaderSendSnapshot ships the current snapshot to a peer whose nextIndex
+// fell behind the compacted prefix. Expects rf.mu to be locked.
+func (rf *Raft) leaderSendSnapshot(peerId int, savedCurrentTerm int, round int) {
+	args := InstallSnapshotArgs{
+		Term:               savedCurrentTerm,
+		LeaderId:           rf.id,
+		LastIncludedIndex:  rf.snapshotIndex,
+		LastIncludedTerm:   rf.snapshotTerm,
+		LastIncludedConfig: rf.baseConfig,
+		Data:               rf.snapshot,
+	}
+
+	sentAt := rf.clock.Now()
+	reply := new(InstallSnapshotReply)
+	rf.server.send(peerId, "Raft.InstallSnapshot", args, reply, func(err error) {
+		if err != nil {
+			return
+		}
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		rf.lastContact[peerId] = rf.clock.Now()
+
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", savedCurrentTerm).
+			Int("peer", peerId).
+			Int("snapshotIndex", args.LastIncludedIndex).
+			Msg("installSnapshot")
+
+		if reply.Term > rf.currentTerm {
+			rf.becomeFollower(reply.Term)
+			return
+		}
+
+		if rf.state == Leader && savedCurrentTerm == reply.Term {
+			rf.recordAck(peerId, round, sentAt)
+			rf.nextIndex[peerId] = max(rf.nextIndex[peerId], args.LastIncludedIndex+1)
+			rf.matchIndex[peerId] = max(rf.matchIndex[peerId], args.LastIncludedIndex)
+		}
+	})
+}
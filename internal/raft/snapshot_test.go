@@ -0,0 +1,148 @@
+package raft
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// submitAll submits commands 0..n-1 to leader and returns the index of the
+// last one.
+func submitAll(tb testing.TB, leader *Server, n int) int {
+	tb.Helper()
+	index := -1
+	for i := range n {
+		if index = leader.Submit(i); index < 0 {
+			tb.Fatalf("submit %d: leader %d stepped down", i, leader.serverId)
+		}
+	}
+	return index
+}
+
+// snapshotAt has s snapshot its state machine, data, at index once it has
+// applied that far.
+func (c *testCluster) snapshotAt(tb testing.TB, s *Server, index int, data []byte) {
+	tb.Helper()
+	c.runUntil(tb, "the entries to be applied", func() bool {
+		s.rf.mu.Lock()
+		defer s.rf.mu.Unlock()
+		return s.rf.lastApplied >= index
+	})
+	s.Snapshot(index, data)
+	if got := s.Inspect().SnapshotIndex; got != index {
+		tb.Fatalf("node %d snapshot at %d, want %d", s.serverId, got, index)
+	}
+}
+
+// sameLog reports whether a and b hold the same snapshot and entries.
+func sameLog(a, b LogView) bool {
+	return a.SnapshotIndex == b.SnapshotIndex && a.SnapshotTerm == b.SnapshotTerm &&
+		slices.EqualFunc(a.Entries, b.Entries, func(x, y LogEntry) bool {
+			return x.Term == y.Term && x.Command == y.Command
+		})
+}
+
+func TestSnapshotCompactsLog(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	index := submitAll(t, leader, 10)
+	c.snapshotAt(t, leader, index-3, []byte("state"))
+
+	view := leader.Inspect()
+	if len(view.Entries) != 3 {
+		t.Errorf("log keeps %d entries past the snapshot, want 3", len(view.Entries))
+	}
+	if view.SnapshotTerm != view.Term {
+		t.Errorf("snapshot term %d, want %d", view.SnapshotTerm, view.Term)
+	}
+
+	// a snapshot at or before the current one changes nothing.
+	leader.Snapshot(index-5, []byte("older"))
+	if got := leader.Inspect().SnapshotIndex; got != index-3 {
+		t.Errorf("older snapshot moved the snapshot to %d", got)
+	}
+}
+
+func TestInstallSnapshotOnLaggingFollower(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	lagging := followerOf(c, leader)
+	c.isolate(lagging)
+
+	index := submitAll(t, leader, 20)
+	data := []byte("state at 20")
+	c.snapshotAt(t, leader, index, data)
+
+	c.connect(t, lagging)
+	c.runUntil(t, "the lagging follower to install the snapshot", func() bool {
+		return lagging.Inspect().SnapshotIndex == index
+	})
+	lagging.rf.mu.Lock()
+	got, commitIndex := lagging.rf.snapshot, lagging.rf.commitIndex
+	lagging.rf.mu.Unlock()
+	if !bytes.Equal(got, data) {
+		t.Errorf("installed snapshot %q, want %q", got, data)
+	}
+	if commitIndex < index {
+		t.Errorf("commitIndex %d after installing the snapshot at %d", commitIndex, index)
+	}
+
+	// replication carries on from the snapshot.
+	next := submitAll(t, leader, 3)
+	c.runUntil(t, "the follower to catch up past the snapshot", func() bool {
+		return lagging.Inspect().CommitIndex >= next
+	})
+	if !sameLog(lagging.Inspect(), leader.Inspect()) {
+		t.Errorf("logs differ:\nfollower %+v\nleader   %+v", lagging.Inspect(), leader.Inspect())
+	}
+}
+
+func TestConflictResolutionPastSnapshot(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	old := c.settledLeader(t)
+	c.isolate(old)
+
+	// entries only the deposed leader ever gets.
+	stale := old.Inspect().CommitIndex
+	for _, cmd := range []string{"stale0", "stale1", "stale2"} {
+		if old.Submit(cmd) < 0 {
+			t.Fatal("isolated leader refused a submit right away")
+		}
+	}
+
+	var leader *Server
+	c.runUntil(t, "a new leader", func() bool {
+		for _, s := range c.servers {
+			if s != old && s.IsLeader() {
+				leader = s
+				return true
+			}
+		}
+		return false
+	})
+	index := submitAll(t, leader, 20)
+	// the snapshot covers every index the stale entries took.
+	if index <= stale+3 {
+		t.Fatalf("new leader at %d hasn't passed the stale entries at %d", index, stale+3)
+	}
+	c.snapshotAt(t, leader, index, []byte("state"))
+
+	c.connect(t, old)
+	c.runUntil(t, "the deposed leader to catch up", func() bool {
+		return old.Inspect().CommitIndex >= index
+	})
+	view := old.Inspect()
+	if view.SnapshotIndex < index {
+		t.Errorf("deposed leader snapshot at %d, want the leader's at %d", view.SnapshotIndex, index)
+	}
+	for _, entry := range view.Entries {
+		if s, ok := entry.Command.(string); ok && strings.HasPrefix(s, "stale") {
+			t.Errorf("stale entry %v survived", entry)
+		}
+	}
+	leaderView := c.leader(t).Inspect()
+	if !sameLog(view, leaderView) {
+		t.Errorf("logs differ:\ndeposed %+v\nleader  %+v", view, leaderView)
+	}
+}
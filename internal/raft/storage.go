@@ -2,21 +2,116 @@
 // also for kv store
 package raft
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
 
+// ErrEntriesGap is returned by Storage.Commit when a batch appends entries
+// that would leave a hole in, or reach before, the stored entries.
+var ErrEntriesGap = errors.New("raft: stored entries not contiguous")
+
+// Storage is where a node keeps its persistent state: small values by key
+// (hard state, snapshot) and the log as a sequence of encoded entries, so a
+// change to the log only costs as much as the entries it touches. Writes
+// come in batches that Commit applies atomically: after a crash either the
+// whole batch is there or none of it. A Commit that returns nil must survive
+// a crash; any error faults the node.
 type Storage interface {
-	Set(key string, value []byte)
+	Commit(b *Batch) error
+
+	Get(key string) ([]byte, bool, error)
 
-	Get(key string) ([]byte, bool)
+	// Entries returns the index of the first stored entry and the entries
+	// from there on.
+	Entries() (int, [][]byte, error)
 
-	// HasData returns true iff any Sets were made on this Storage.
+	// HasData returns true iff any batch was committed to this Storage.
 	HasData() bool
 }
 
+// BatchOpKind says what a BatchOp does.
+type BatchOpKind int
+
+const (
+	// BatchSet stores Value under Key.
+	BatchSet BatchOpKind = iota
+	// BatchAppend stores Entries at Index onwards, dropping every stored
+	// entry from Index on first.
+	BatchAppend
+	// BatchCompact drops the stored entries before Index. If that leaves
+	// none, the next append starts at Index.
+	BatchCompact
+)
+
+// BatchOp is one write in a Batch.
+type BatchOp struct {
+	Kind    BatchOpKind
+	Key     string
+	Value   []byte
+	Index   int
+	Entries [][]byte
+}
+
+// Batch is a group of writes that Storage.Commit applies in order, as one.
+type Batch struct {
+	ops []BatchOp
+}
+
+func (b *Batch) Set(key string, value []byte) {
+	b.ops = append(b.ops, BatchOp{Kind: BatchSet, Key: key, Value: value})
+}
+
+// AppendEntries stores entries at index onwards, replacing whatever was
+// stored from index on. index is at most one past the last stored entry and
+// no lower than the first.
+func (b *Batch) AppendEntries(index int, entries [][]byte) {
+	b.ops = append(b.ops, BatchOp{Kind: BatchAppend, Index: index, Entries: entries})
+}
+
+// CompactEntries drops the stored entries before index.
+func (b *Batch) CompactEntries(index int) {
+	b.ops = append(b.ops, BatchOp{Kind: BatchCompact, Index: index})
+}
+
+// Ops returns the writes in the batch, in order.
+func (b *Batch) Ops() []BatchOp {
+	return b.ops
+}
+
+// Empty reports whether the batch holds no writes.
+func (b *Batch) Empty() bool {
+	return len(b.ops) == 0
+}
+
+// check verifies that the batch can be applied to entries [first, next)
+// without leaving a gap, so a Storage can reject it before writing anything.
+func (b *Batch) check(first, next int) error {
+	for _, op := range b.ops {
+		switch op.Kind {
+		case BatchAppend:
+			if op.Index < first || op.Index > next {
+				return fmt.Errorf("%w: appending at %d, holding [%d, %d)", ErrEntriesGap, op.Index, first, next)
+			}
+			next = op.Index + len(op.Entries)
+		case BatchCompact:
+			if op.Index > first {
+				next = max(next, op.Index)
+				first = op.Index
+			}
+		}
+	}
+	return nil
+}
+
 // MapStorage is a simple in-memory implementation of Storage for testing.
 type MapStorage struct {
-	mu sync.Mutex
-	m  map[string][]byte
+	mu         sync.Mutex
+	m          map[string][]byte
+	firstIndex int
+	entries    [][]byte
 }
 
 func NewMapStorage() *MapStorage {
@@ -26,21 +121,52 @@ func NewMapStorage() *MapStorage {
 	}
 }
 
-func (ms *MapStorage) Get(key string) ([]byte, bool) {
+func (ms *MapStorage) Get(key string) ([]byte, bool, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 	v, found := ms.m[key]
-	return v, found
+	return v, found, nil
+}
+
+func (ms *MapStorage) Commit(b *Batch) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if err := b.check(ms.firstIndex, ms.firstIndex+len(ms.entries)); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		switch op.Kind {
+		case BatchSet:
+			ms.m[op.Key] = op.Value
+		case BatchAppend:
+			ms.entries = append(ms.entries[:op.Index-ms.firstIndex], op.Entries...)
+		case BatchCompact:
+			ms.compact(op.Index)
+		}
+	}
+	return nil
+}
+
+func (ms *MapStorage) compact(index int) {
+	switch {
+	case index <= ms.firstIndex:
+		return
+	case index >= ms.firstIndex+len(ms.entries):
+		ms.entries = nil
+	default:
+		ms.entries = slices.Clone(ms.entries[index-ms.firstIndex:])
+	}
+	ms.firstIndex = index
 }
 
-func (ms *MapStorage) Set(key string, value []byte) {
+func (ms *MapStorage) Entries() (int, [][]byte, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
-	ms.m[key] = value
+	return ms.firstIndex, slices.Clone(ms.entries), nil
 }
 
 func (ms *MapStorage) HasData() bool {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
-	return len(ms.m) > 0
+	return len(ms.m) > 0 || len(ms.entries) > 0
 }
@@ -0,0 +1,148 @@
+// TN RPC
+// Leadership transfer from section 3.10 of the Raft thesis: the leader stops
+// taking proposals, brings the target's log up to date and then tells it to
+// time out right away with TimeoutNow, so it wins the next election.
+package raft
+
+import (
+	"errors"
+	"slices"
+)
+
+var (
+	ErrTransferInProgress = errors.New("raft: leadership transfer in progress")
+	ErrTransferTimeout    = errors.New("raft: leadership transfer timed out")
+	ErrNoTransferTarget   = errors.New("raft: no voter to transfer leadership to")
+)
+
+type TimeoutNowArgs struct {
+	Term     int
+	LeaderId int
+}
+
+type TimeoutNowReply struct {
+	Term int
+}
+
+// TransferLeadership hands leadership to target, or to the most up-to-date
+// voter when target is negative. It blocks until this node has stepped down
+// or the transfer gave up after an election timeout, in which case the node
+// stays leader and accepts proposals again.
+func (rf *Raft) TransferLeadership(target int) error {
+	rf.mu.Lock()
+	if rf.state != Leader {
+		rf.mu.Unlock()
+		return ErrNotLeader
+	}
+	if rf.transferTarget >= 0 {
+		rf.mu.Unlock()
+		return ErrTransferInProgress
+	}
+	if target < 0 {
+		target = rf.mostUpToDatePeer()
+	}
+	if target < 0 || target == rf.id || !slices.Contains(rf.config, target) {
+		rf.mu.Unlock()
+		return ErrNoTransferTarget
+	}
+	rf.transferTarget = target
+	savedCurrentTerm := rf.currentTerm
+	rf.mu.Unlock()
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", savedCurrentTerm).
+		Int("peer", target).
+		Msg("leadershipTransferStarted")
+
+	err := rf.runTransfer(target, savedCurrentTerm)
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.transferTarget = -1
+	if err != nil {
+		rf.logger.Info().
+			Int("raftID", rf.id).
+			Int("term", savedCurrentTerm).
+			Int("peer", target).
+			Str("error", err.Error()).
+			Msg("leadershipTransferFailed")
+	}
+	return err
+}
+
+// runTransfer waits for target to catch up, fires TimeoutNow and waits for
+// the resulting election to depose this leader.
+func (rf *Raft) runTransfer(target int, savedCurrentTerm int) error {
+	deadline := rf.clock.Now().Add(rf.cfg.ElectionTimeoutMax)
+	sent := false
+
+	for rf.clock.Now().Before(deadline) {
+		rf.mu.Lock()
+		if rf.state != Leader || rf.currentTerm != savedCurrentTerm {
+			rf.mu.Unlock()
+			return nil
+		}
+		caughtUp := rf.matchIndex[target] >= rf.logLen()-1
+		if !sent && !caughtUp {
+			// nudge replication towards the target.
+			rf.triggerAE()
+		}
+		rf.mu.Unlock()
+
+		if !sent && caughtUp {
+			args := TimeoutNowArgs{Term: savedCurrentTerm, LeaderId: rf.id}
+			var reply TimeoutNowReply
+			if err := rf.server.Call(target, "Raft.TimeoutNow", args, &reply); err != nil {
+				return err
+			}
+			rf.logger.Info().
+				Int("raftID", rf.id).
+				Int("term", savedCurrentTerm).
+				Int("peer", target).
+				Msg("timeoutNow")
+			sent = true
+		}
+		<-rf.clock.After(rf.cfg.TickInterval)
+	}
+	return ErrTransferTimeout
+}
+
+// TimeoutNow makes this node start an election immediately, skipping PreVote
+// since the current leader asked for it.
+func (rf *Raft) TimeoutNow(args TimeoutNowArgs, reply *TimeoutNowReply) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.state == Dead {
+		return nil
+	}
+	if rf.state == Faulted {
+		return ErrStorageFault
+	}
+
+	reply.Term = rf.currentTerm
+	if args.Term != rf.currentTerm || rf.state == Leader || !rf.isVoter() {
+		return nil
+	}
+
+	rf.logger.Info().
+		Int("raftID", rf.id).
+		Int("term", rf.currentTerm).
+		Int("peer", args.LeaderId).
+		Msg("timeoutNowReceived")
+	rf.startElection(true)
+	return nil
+}
+
+// mostUpToDatePeer picks the voter with the highest matchIndex, or -1.
+// Expects rf.mu to be locked.
+func (rf *Raft) mostUpToDatePeer() int {
+	best := -1
+	for _, peerId := range rf.peerIds {
+		if best < 0 || rf.matchIndex[peerId] > rf.matchIndex[best] {
+			best = peerId
+		}
+	}
+	return best
+}
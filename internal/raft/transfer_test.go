@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransferLeadership(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	term := leader.Inspect().Term
+	target := followerOf(c, leader)
+	// the target has to catch up on these before it gets TimeoutNow.
+	index := submitAll(t, leader, 5)
+
+	var err error
+	c.await(t, func() { err = leader.TransferLeadership(target.serverId) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !target.IsLeader() {
+		t.Fatalf("node %d leads after the transfer, want %d", c.leader(t).serverId, target.serverId)
+	}
+	if got := target.Inspect().Term; got != term+1 {
+		t.Errorf("target leads term %d, want %d", got, term+1)
+	}
+	if leader.IsLeader() {
+		t.Error("old leader still leads")
+	}
+	if got := target.Inspect(); len(got.Entries) <= index {
+		t.Errorf("new leader's log ends before %d", index)
+	}
+}
+
+func TestTransferLeadershipToMostUpToDate(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+
+	var err error
+	c.await(t, func() { err = leader.TransferLeadership(-1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := c.leader(t); next == leader {
+		t.Error("leadership stayed with the node handing it off")
+	}
+}
+
+func TestTransferLeadershipRefused(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	follower := followerOf(c, leader)
+
+	tests := []struct {
+		name   string
+		server *Server
+		target int
+		want   error
+	}{
+		{"from a follower", follower, leader.serverId, ErrNotLeader},
+		{"to itself", leader, leader.serverId, ErrNoTransferTarget},
+		{"to a stranger", leader, 7, ErrNoTransferTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.server.TransferLeadership(tt.target); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if !leader.IsLeader() {
+		t.Error("refused transfers cost the leader its leadership")
+	}
+}
+
+func TestTransferLeadershipToUnreachableTarget(t *testing.T) {
+	c := newVirtualTestCluster(t, 3)
+	leader := c.settledLeader(t)
+	target := followerOf(c, leader)
+	c.isolate(target)
+	// the target can't catch up on it.
+	submitAll(t, leader, 1)
+
+	var err error
+	c.await(t, func() { err = leader.TransferLeadership(target.serverId) })
+	if !errors.Is(err, ErrTransferTimeout) {
+		t.Errorf("got %v, want ErrTransferTimeout", err)
+	}
+	// a failed transfer leaves the leader leading and taking proposals.
+	if !leader.IsLeader() {
+		t.Fatal("leader stepped down after the failed transfer")
+	}
+	p := leader.Propose(context.Background(), "after")
+	c.runUntil(t, "the proposal to commit", func() bool {
+		select {
+		case <-p.Done():
+			return true
+		default:
+			return false
+		}
+	})
+	if _, _, err := p.Wait(); err != nil {
+		t.Errorf("proposal after the failed transfer: %v", err)
+	}
+}
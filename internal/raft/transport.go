@@ -0,0 +1,219 @@
+// TRANSPORT
+// How RPCs travel between peers. A Server hands its RPCProxy to a Transport
+// to serve and sends every outgoing call through it, so the Raft core only
+// ever names peers by id. TCPTransport, net/rpc over TCP, is what a Server
+// uses unless Config.NewTransport provides another.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrPeerDisconnected is returned for calls to a peer the transport has
+	// no connection to, or lost it while waiting.
+	ErrPeerDisconnected = errors.New("raft: peer disconnected")
+	// ErrTransportClosed is returned by Serve on a transport already closed.
+	ErrTransportClosed = errors.New("raft: transport closed")
+)
+
+// Handler serves the RPCs a node receives from its peers. Calls name its
+// methods as "Raft.<Method>".
+type Handler interface {
+	RequestVote(args RequestVoteArgs, reply *RequestVoteReply) error
+	PreVote(args PreVoteArgs, reply *PreVoteReply) error
+	AppendEntries(args AppendEntriesArgs, reply *AppendEntriesReply) error
+	InstallSnapshot(args InstallSnapshotArgs, reply *InstallSnapshotReply) error
+	TimeoutNow(args TimeoutNowArgs, reply *TimeoutNowReply) error
+}
+
+// Transport connects one node to its peers.
+type Transport interface {
+	// Serve starts handing calls from peers to handler.
+	Serve(handler Handler) error
+
+	// Addr is what peers pass to Connect to reach this node.
+	Addr() net.Addr
+
+	Connect(peerId int, addr net.Addr) error
+	Disconnect(peerId int) error
+	DisconnectAll()
+
+	// Call invokes method, e.g. "Raft.AppendEntries", on peerId and waits
+	// for its reply.
+	Call(peerId int, method string, args any, reply any) error
+
+	// Close stops serving, waits for calls being served to return and drops
+	// every connection.
+	Close() error
+}
+
+// TCPTransport serves calls with net/rpc on a TCP port picked by the system
+// and dials one net/rpc client per peer.
+type TCPTransport struct {
+	mu sync.Mutex
+	id int
+
+	rpcServer *rpc.Server
+	listener  net.Listener
+	clients   map[int]*rpc.Client
+
+	quit chan any
+	wg   sync.WaitGroup
+
+	logger *zerolog.Logger
+}
+
+func NewTCPTransport(id int) *TCPTransport {
+	return &TCPTransport{
+		id:      id,
+		clients: make(map[int]*rpc.Client),
+		quit:    make(chan any),
+		logger:  &log.Logger,
+	}
+}
+
+// Serve registers handler and accepts connections in a separate goroutine.
+func (t *TCPTransport) Serve(handler Handler) error {
+	t.mu.Lock()
+	t.rpcServer = rpc.NewServer()
+	if err := t.rpcServer.RegisterName("Raft", handler); err != nil {
+		t.mu.Unlock()
+		return err
+	}
+
+	var err error
+	t.listener, err = net.Listen("tcp", ":0")
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.logger.Info().
+		Int("raftID", t.id).
+		Str("address", t.listener.Addr().String()).
+		Msg("serverListening")
+	listener := t.listener
+	t.mu.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-t.quit:
+					return
+				default:
+					t.logger.Error().Err(err).Msg("Accept error while listening for RPC connections")
+				}
+			} else {
+				t.wg.Add(1)
+				go func() {
+					t.rpcServer.ServeConn(conn)
+					t.wg.Done()
+				}()
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *TCPTransport) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener.Addr()
+}
+
+func (t *TCPTransport) Connect(peerId int, addr net.Addr) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[peerId] == nil {
+		client, err := rpc.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.logger.Error().Err(err).Int("serverId", t.id).Int("peerId", peerId).Msg("Failed to connect to peer")
+			return err
+		}
+		t.clients[peerId] = client
+		t.logger.Info().
+			Int("raftID", t.id).
+			Int("peer", peerId).
+			Str("address", addr.String()).
+			Msg("peerConnected")
+	}
+	return nil
+}
+
+// Disconnect closes the connection to peerId.
+func (t *TCPTransport) Disconnect(peerId int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[peerId] != nil {
+		err := t.clients[peerId].Close()
+		t.clients[peerId] = nil
+		if err != nil {
+			t.logger.Error().Err(err).Int("peer", peerId).Msg("Failed to disconnect from peer")
+		} else {
+			t.logger.Info().Int("peer", peerId).Msg("peerDisconnected")
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *TCPTransport) DisconnectAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeClients()
+	t.logger.Info().
+		Int("raftID", t.id).
+		Msg("disconnectionComplete")
+}
+
+// closeClients expects t.mu to be locked.
+func (t *TCPTransport) closeClients() {
+	for id := range t.clients {
+		if t.clients[id] != nil {
+			_ = t.clients[id].Close() // ignoring close error
+			t.clients[id] = nil
+		}
+	}
+}
+
+func (t *TCPTransport) Call(peerId int, method string, args any, reply any) error {
+	t.mu.Lock()
+	peer := t.clients[peerId]
+	t.mu.Unlock()
+
+	if peer == nil {
+		return peerDisconnected(peerId)
+	}
+	return peer.Call(method, args, reply)
+}
+
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	close(t.quit)
+	var err error
+	if t.listener != nil {
+		err = t.listener.Close()
+	}
+	t.closeClients()
+	t.mu.Unlock()
+
+	t.wg.Wait()
+	return err
+}
+
+func peerDisconnected(peerId int) error {
+	return fmt.Errorf("call client %d after it's closed: %w", peerId, ErrPeerDisconnected)
+}
@@ -0,0 +1,631 @@
+// WAL STORAGE
+// A file-backed Storage. Every change is appended to the active segment as a
+// record carrying its own CRC; nothing already written is ever rewritten.
+// Once a segment grows past SegmentSize the live state is checkpointed into a
+// fresh segment and the old ones are deleted, so every segment is
+// self-contained: a checkpoint followed by the changes made since.
+//
+// Besides plain values, the WAL understands log entries directly: appending
+// writes only the new entries, a conflicting suffix is dropped with a
+// truncation record and a snapshot drops a prefix with a compaction record.
+// The records of one Batch are wrapped in a single batch record, whose CRC
+// makes the batch all or nothing.
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy says when a WALStorage fsyncs the active segment.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write, before it returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every WALOptions.SyncInterval;
+	// a crash may lose the writes of the last interval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// WALOptions tune a WALStorage.
+type WALOptions struct {
+	SegmentSize  int64 // bytes written to a segment before it is checkpointed and rotated
+	Sync         SyncPolicy
+	SyncInterval time.Duration // only used with SyncInterval
+}
+
+// DefaultWALOptions fsyncs every write and rotates at 4MB.
+func DefaultWALOptions() WALOptions {
+	return WALOptions{
+		SegmentSize:  4 << 20,
+		Sync:         SyncAlways,
+		SyncInterval: 10 * time.Millisecond,
+	}
+}
+
+var (
+	ErrWALCorrupt = errors.New("raft: WAL corrupt")
+	ErrWALClosed  = errors.New("raft: WAL closed")
+)
+
+type walRecordType byte
+
+const (
+	walSet walRecordType = iota + 1
+	walAppend
+	walTruncate
+	walCompact
+	walCheckpointEnd
+	walBatch // records of one Batch, applied together
+)
+
+const (
+	walHeaderSize    = 9 // length (4), crc (4), type (1)
+	walMaxRecordSize = 1 << 30
+	walSegmentExt    = ".wal"
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WALStorage is a Storage kept in a directory of append-only segment files.
+type WALStorage struct {
+	mu   sync.Mutex
+	dir  string
+	opts WALOptions
+
+	seq            uint64   // sequence number of the active segment
+	file           *os.File // active segment
+	size           int64    // bytes in the active segment
+	checkpointSize int64    // bytes of the active segment taken by its checkpoint
+	recoveredLen   int64    // bytes of torn tail dropped while opening
+	err            error    // first failed write or sync, see write
+	closed         bool
+	stopSync       chan struct{}
+	syncDone       chan struct{}
+
+	// live state, as replayed from disk.
+	kv         map[string][]byte
+	firstIndex int      // log index of entries[0]
+	entries    [][]byte // encoded entries from firstIndex on
+}
+
+// OpenWALStorage opens the WAL in dir, creating dir if needed, and recovers
+// the state written before the last shutdown or crash. A torn record at the
+// very end of the active segment, i.e. a write cut short by the crash, is
+// dropped. A record failing its CRC anywhere else is corruption no crash
+// explains, and opening fails with ErrWALCorrupt, leaving the files as they
+// are.
+func OpenWALStorage(dir string, opts WALOptions) (*WALStorage, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultWALOptions().SegmentSize
+	}
+	if opts.Sync == SyncInterval && opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultWALOptions().SyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	w := &WALStorage{
+		dir:  dir,
+		opts: opts,
+		kv:   make(map[string][]byte),
+	}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		w.stopSync = make(chan struct{})
+		w.syncDone = make(chan struct{})
+		go w.runSync()
+	}
+	return w, nil
+}
+
+// TornTail returns how many bytes of torn tail opening the WAL dropped, 0 if
+// the last shutdown left it intact.
+func (w *WALStorage) TornTail() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recoveredLen
+}
+
+func (w *WALStorage) Get(key string) ([]byte, bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v, found := w.kv[key]
+	return v, found, nil
+}
+
+// Commit writes the whole batch as a single record, so a crash in the middle
+// leaves a torn record that recovery drops rather than half a batch.
+func (w *WALStorage) Commit(b *Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := b.check(w.firstIndex, w.firstIndex+len(w.entries)); err != nil {
+		return err
+	}
+
+	var inner []byte
+	first, next := w.firstIndex, w.firstIndex+len(w.entries)
+	for _, op := range b.ops {
+		switch op.Kind {
+		case BatchSet:
+			inner = append(inner, walSetRecord(op.Key, op.Value)...)
+		case BatchAppend:
+			if op.Index < next {
+				inner = append(inner, walIndexRecord(walTruncate, op.Index)...)
+			}
+			for i, entry := range op.Entries {
+				inner = append(inner, walAppendRecord(op.Index+i, entry)...)
+			}
+			next = op.Index + len(op.Entries)
+		case BatchCompact:
+			if op.Index > first {
+				inner = append(inner, walIndexRecord(walCompact, op.Index)...)
+				next = max(next, op.Index)
+				first = op.Index
+			}
+		}
+	}
+	if len(inner) == 0 {
+		return nil
+	}
+	if err := w.write(walRecord(walBatch, inner)); err != nil {
+		return err
+	}
+	return w.apply(walBatch, inner)
+}
+
+func (w *WALStorage) HasData() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.kv) > 0 || len(w.entries) > 0 || w.firstIndex > 0
+}
+
+// Entries returns the index of the first stored entry and the encoded
+// entries from there on.
+func (w *WALStorage) Entries() (int, [][]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.firstIndex, slices.Clone(w.entries), nil
+}
+
+// Sync fsyncs the active segment regardless of the sync policy.
+func (w *WALStorage) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWALClosed
+	}
+	return w.file.Sync()
+}
+
+// Close syncs and closes the active segment. The WAL can be reopened with
+// OpenWALStorage.
+func (w *WALStorage) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	if w.stopSync != nil {
+		close(w.stopSync)
+		<-w.syncDone
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// runSync is the background fsync loop for SyncInterval.
+func (w *WALStorage) runSync() {
+	defer close(w.syncDone)
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopSync:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.err == nil {
+				if err := w.file.Sync(); err != nil {
+					w.err = err
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// write appends encoded records to the active segment and syncs them
+// according to the policy. A segment that got too big is rotated first, so
+// the checkpoint never has to include the records being written. After a
+// failed write or sync the segment may end in a partial record, so every
+// later write fails with the same error. Expects w.mu to be locked.
+func (w *WALStorage) write(records []byte) error {
+	if w.closed {
+		return ErrWALClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.writeRecords(records)
+	return w.err
+}
+
+func (w *WALStorage) writeRecords(records []byte) error {
+	if w.size-w.checkpointSize >= w.opts.SegmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(records)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if w.opts.Sync == SyncAlways {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+// rotate checkpoints the live state into a new segment and deletes the old
+// ones. Until the checkpoint is complete and synced the old segment stays the
+// one recovery uses, so it is synced first: only the newest segment may end
+// in a torn record. Expects w.mu to be locked.
+func (w *WALStorage) rotate() error {
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	seq := w.seq + 1
+	file, size, err := w.createSegment(seq)
+	if err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	oldSeq := w.seq
+	w.seq, w.file, w.size, w.checkpointSize = seq, file, size, size
+	return w.removeSegments(func(s uint64) bool { return s <= oldSeq })
+}
+
+// createSegment writes a checkpoint of the live state to a new segment seq
+// and syncs it. Expects w.mu to be locked.
+func (w *WALStorage) createSegment(seq uint64) (*os.File, int64, error) {
+	file, err := os.OpenFile(w.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var buf []byte
+	keys := make([]string, 0, len(w.kv))
+	for key := range w.kv {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		buf = append(buf, walSetRecord(key, w.kv[key])...)
+	}
+	buf = append(buf, walIndexRecord(walCompact, w.firstIndex)...)
+	for i, entry := range w.entries {
+		buf = append(buf, walAppendRecord(w.firstIndex+i, entry)...)
+	}
+	buf = append(buf, walRecord(walCheckpointEnd, nil)...)
+
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if err := syncDir(w.dir); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, int64(len(buf)), nil
+}
+
+// recover loads the newest segment whose checkpoint is complete, cuts off
+// its torn tail, if any, and deletes every other segment.
+func (w *WALStorage) recover() error {
+	seqs, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for i := len(seqs) - 1; i >= 0; i-- {
+		w.kv = make(map[string][]byte)
+		w.firstIndex, w.entries = 0, nil
+		newest := i == len(seqs)-1
+		checkpointSize, good, err := w.replay(seqs[i], newest)
+		if err != nil {
+			return err
+		}
+		if checkpointSize < 0 {
+			if !newest {
+				return fmt.Errorf("%w: segment %d has no complete checkpoint", ErrWALCorrupt, seqs[i])
+			}
+			// crashed while writing this checkpoint; the previous segment
+			// is still intact.
+			continue
+		}
+
+		file, err := os.OpenFile(w.segmentPath(seqs[i]), os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if info.Size() > good {
+			w.recoveredLen = info.Size() - good
+			if err := file.Truncate(good); err != nil {
+				file.Close()
+				return err
+			}
+			if err := file.Sync(); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		if _, err := file.Seek(good, io.SeekStart); err != nil {
+			file.Close()
+			return err
+		}
+		w.seq, w.file, w.size, w.checkpointSize = seqs[i], file, good, checkpointSize
+		return w.removeSegments(func(s uint64) bool { return s != w.seq })
+	}
+
+	// nothing usable on disk: start over with an empty checkpoint.
+	w.kv = make(map[string][]byte)
+	w.firstIndex, w.entries = 0, nil
+	seq := uint64(1)
+	if len(seqs) > 0 {
+		seq = seqs[len(seqs)-1] + 1
+	}
+	file, size, err := w.createSegment(seq)
+	if err != nil {
+		return err
+	}
+	w.seq, w.file, w.size, w.checkpointSize = seq, file, size, size
+	return w.removeSegments(func(s uint64) bool { return s != seq })
+}
+
+// replay applies the records of segment seq to the live state. It returns
+// the size of the segment's checkpoint, -1 if the checkpoint is incomplete,
+// and the offset just past the last intact record. Only the newest segment
+// may end in a torn record; one that doesn't decode anywhere else is
+// ErrWALCorrupt.
+func (w *WALStorage) replay(seq uint64, newest bool) (int64, int64, error) {
+	data, err := os.ReadFile(w.segmentPath(seq))
+	if err != nil {
+		return -1, 0, err
+	}
+
+	checkpointSize := int64(-1)
+	off := int64(0)
+	for off < int64(len(data)) {
+		typ, payload, n, ok := walDecode(data[off:])
+		if !ok {
+			if newest && walTorn(data[off:]) {
+				return checkpointSize, off, nil
+			}
+			return checkpointSize, off, fmt.Errorf("%w: segment %d has a bad record at offset %d", ErrWALCorrupt, seq, off)
+		}
+		if err := w.apply(typ, payload); err != nil {
+			return checkpointSize, off, fmt.Errorf("segment %d at offset %d: %w", seq, off, err)
+		}
+		off += n
+		if typ == walCheckpointEnd {
+			checkpointSize = off
+		}
+	}
+	return checkpointSize, off, nil
+}
+
+// apply replays one record onto the live state.
+func (w *WALStorage) apply(typ walRecordType, payload []byte) error {
+	switch typ {
+	case walSet:
+		keyLen, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < keyLen {
+			return ErrWALCorrupt
+		}
+		key := string(payload[n : n+int(keyLen)])
+		w.kv[key] = slices.Clone(payload[n+int(keyLen):])
+	case walAppend:
+		index, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return ErrWALCorrupt
+		}
+		if int(index) != w.firstIndex+len(w.entries) {
+			return fmt.Errorf("%w: entry %d, next is %d", ErrEntriesGap, index, w.firstIndex+len(w.entries))
+		}
+		w.entries = append(w.entries, slices.Clone(payload[n:]))
+	case walTruncate, walCompact:
+		index, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return ErrWALCorrupt
+		}
+		if typ == walTruncate {
+			w.truncate(int(index))
+		} else {
+			w.compact(int(index))
+		}
+	case walBatch:
+		for len(payload) > 0 {
+			innerTyp, innerPayload, n, ok := walDecode(payload)
+			if !ok || innerTyp == walBatch {
+				return ErrWALCorrupt
+			}
+			if err := w.apply(innerTyp, innerPayload); err != nil {
+				return err
+			}
+			payload = payload[n:]
+		}
+	case walCheckpointEnd:
+	default:
+		return fmt.Errorf("%w: unknown record type %d", ErrWALCorrupt, typ)
+	}
+	return nil
+}
+
+func (w *WALStorage) truncate(index int) {
+	if index < w.firstIndex {
+		index = w.firstIndex
+	}
+	if index-w.firstIndex < len(w.entries) {
+		w.entries = w.entries[:index-w.firstIndex]
+	}
+}
+
+func (w *WALStorage) compact(index int) {
+	switch {
+	case index <= w.firstIndex:
+	case index >= w.firstIndex+len(w.entries):
+		w.entries = nil
+	default:
+		w.entries = slices.Clone(w.entries[index-w.firstIndex:])
+	}
+	w.firstIndex = max(w.firstIndex, index)
+}
+
+// segments lists the sequence numbers of the segments in w.dir, oldest first.
+func (w *WALStorage) segments() ([]uint64, error) {
+	names, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, entry := range names {
+		name := entry.Name()
+		if !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, walSegmentExt), "%016x", &seq); err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+func (w *WALStorage) removeSegments(drop func(seq uint64) bool) error {
+	seqs, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if drop(seq) {
+			if err := os.Remove(w.segmentPath(seq)); err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(w.dir)
+}
+
+func (w *WALStorage) segmentPath(seq uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016x%s", seq, walSegmentExt))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// walRecord frames payload as a record: length and CRC of type+payload, then
+// type and payload.
+func walRecord(typ walRecordType, payload []byte) []byte {
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	buf[8] = byte(typ)
+	buf = append(buf, payload...)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)-8))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], walCRCTable))
+	return buf
+}
+
+func walSetRecord(key string, value []byte) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+	return walRecord(walSet, payload)
+}
+
+func walAppendRecord(index int, entry []byte) []byte {
+	payload := binary.AppendUvarint(nil, uint64(index))
+	payload = append(payload, entry...)
+	return walRecord(walAppend, payload)
+}
+
+func walIndexRecord(typ walRecordType, index int) []byte {
+	return walRecord(typ, binary.AppendUvarint(nil, uint64(index)))
+}
+
+// walTorn reports whether rest, everything from a record that didn't decode
+// to the end of the segment, is a write cut short by a crash: a record
+// reaching up to or past the end, whose tail never made it to disk, or a
+// run of zeros the file system extended the file with.
+func walTorn(rest []byte) bool {
+	if len(rest) < walHeaderSize {
+		return true
+	}
+	length := binary.LittleEndian.Uint32(rest[0:4])
+	switch {
+	case length == 0:
+		return !slices.ContainsFunc(rest, func(b byte) bool { return b != 0 })
+	case length > walMaxRecordSize:
+		return false
+	default:
+		return uint64(len(rest)-8) <= uint64(length)
+	}
+}
+
+// walDecode reads the record at the start of data. ok is false if data is
+// empty or starts with a record that is cut short or fails its CRC.
+func walDecode(data []byte) (typ walRecordType, payload []byte, n int64, ok bool) {
+	if len(data) < walHeaderSize {
+		return 0, nil, 0, false
+	}
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if length == 0 || length > walMaxRecordSize || uint64(len(data)-8) < uint64(length) {
+		return 0, nil, 0, false
+	}
+	body := data[8 : 8+length]
+	if crc32.Checksum(body, walCRCTable) != binary.LittleEndian.Uint32(data[4:8]) {
+		return 0, nil, 0, false
+	}
+	return walRecordType(body[0]), body[1:], int64(8 + length), true
+}
@@ -0,0 +1,294 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func openWAL(t *testing.T, dir string, opts WALOptions) *WALStorage {
+	t.Helper()
+	w, err := OpenWALStorage(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func commit(t *testing.T, w *WALStorage, fill func(b *Batch)) {
+	t.Helper()
+	var b Batch
+	fill(&b)
+	if err := w.Commit(&b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func entryBytes(values ...string) [][]byte {
+	var entries [][]byte
+	for _, v := range values {
+		entries = append(entries, []byte(v))
+	}
+	return entries
+}
+
+// checkWAL fails t unless w holds the entries from first on and key holds
+// value.
+func checkWAL(t *testing.T, w *WALStorage, first int, entries []string, key, value string) {
+	t.Helper()
+	gotFirst, got, err := w.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotFirst != first || !slices.EqualFunc(got, entryBytes(entries...), slices.Equal) {
+		t.Errorf("entries from %d: %q, want from %d: %q", gotFirst, got, first, entries)
+	}
+	v, found, err := w.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || string(v) != value {
+		t.Errorf("%s = %q (found %v), want %q", key, v, found, value)
+	}
+}
+
+func segmentFiles(t *testing.T, w *WALStorage) []uint64 {
+	t.Helper()
+	seqs, err := w.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestWALDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir, DefaultWALOptions())
+	commit(t, w, func(b *Batch) {
+		b.Set("term", []byte("1"))
+		b.AppendEntries(0, entryBytes("a", "b"))
+	})
+	path := w.segmentPath(w.seq)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intact := info.Size()
+	commit(t, w, func(b *Batch) {
+		b.Set("term", []byte("2"))
+		b.AppendEntries(2, entryBytes("c"))
+	})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash cuts the second batch short at every possible byte.
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size := intact; size < info.Size(); size++ {
+		if err := os.WriteFile(path, full[:size], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		w := openWAL(t, dir, DefaultWALOptions())
+		checkWAL(t, w, 0, []string{"a", "b"}, "term", "1")
+		if w.TornTail() != size-intact {
+			t.Errorf("cut at %d: dropped %d bytes, want %d", size, w.TornTail(), size-intact)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != intact {
+			t.Fatalf("cut at %d: torn tail left on disk", size)
+		}
+	}
+
+	// zeros the file system padded the segment with are a torn tail too.
+	padded := append(slices.Clone(full[:intact]), make([]byte, 64)...)
+	if err := os.WriteFile(path, padded, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w = openWAL(t, dir, DefaultWALOptions())
+	checkWAL(t, w, 0, []string{"a", "b"}, "term", "1")
+	w.Close()
+}
+
+func TestWALRejectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir, DefaultWALOptions())
+	path := w.segmentPath(w.seq)
+	var sizes []int64
+	for i := range 3 {
+		commit(t, w, func(b *Batch) {
+			b.AppendEntries(i, entryBytes(fmt.Sprint(i)))
+		})
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"flipped byte in a middle record", func(data []byte) []byte {
+			data[sizes[0]+walHeaderSize] ^= 0xff
+			return data
+		}},
+		{"flipped byte in the checkpoint", func(data []byte) []byte {
+			data[walHeaderSize] ^= 0xff
+			return data
+		}},
+		{"zeroed middle record", func(data []byte) []byte {
+			clear(data[sizes[0]:sizes[1]])
+			return data
+		}},
+		{"impossible length past the last record", func(data []byte) []byte {
+			return append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, byte(walAppend))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(slices.Clone(full))
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := OpenWALStorage(dir, DefaultWALOptions())
+			if !errors.Is(err, ErrWALCorrupt) {
+				t.Fatalf("opened with %v, want ErrWALCorrupt", err)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(after, data) {
+				t.Error("corrupt segment was modified")
+			}
+		})
+	}
+}
+
+func TestWALRotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultWALOptions()
+	opts.SegmentSize = 256
+	w := openWAL(t, dir, opts)
+	first := w.seq
+
+	var want []string
+	for i := range 100 {
+		value := fmt.Sprintf("entry%03d", i)
+		want = append(want, value)
+		commit(t, w, func(b *Batch) {
+			b.Set("last", []byte(value))
+			b.AppendEntries(i, entryBytes(value))
+		})
+	}
+	if w.seq == first {
+		t.Fatal("segment never rotated")
+	}
+	if seqs := segmentFiles(t, w); !slices.Equal(seqs, []uint64{w.seq}) {
+		t.Errorf("segments %v left after rotating to %d", seqs, w.seq)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openWAL(t, dir, opts)
+	defer w.Close()
+	checkWAL(t, w, 0, want, "last", "entry099")
+}
+
+func TestWALTruncatesConflictingEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir, DefaultWALOptions())
+	commit(t, w, func(b *Batch) {
+		b.Set("term", []byte("1"))
+		b.AppendEntries(0, entryBytes("a", "b", "c", "d"))
+	})
+	// a new leader overwrites the suffix from index 2, twice in one batch.
+	commit(t, w, func(b *Batch) {
+		b.Set("term", []byte("2"))
+		b.AppendEntries(2, entryBytes("x", "y", "z"))
+		b.AppendEntries(3, entryBytes("q"))
+	})
+	checkWAL(t, w, 0, []string{"a", "b", "x", "q"}, "term", "2")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openWAL(t, dir, DefaultWALOptions())
+	defer w.Close()
+	checkWAL(t, w, 0, []string{"a", "b", "x", "q"}, "term", "2")
+
+	var gap Batch
+	gap.AppendEntries(7, entryBytes("e"))
+	if err := w.Commit(&gap); !errors.Is(err, ErrEntriesGap) {
+		t.Errorf("append past the end: %v, want ErrEntriesGap", err)
+	}
+}
+
+func TestWALCompactsThroughCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultWALOptions()
+	opts.SegmentSize = 128
+	w := openWAL(t, dir, opts)
+	commit(t, w, func(b *Batch) {
+		b.Set("snapshot", []byte("s0"))
+		b.AppendEntries(0, entryBytes("a", "b", "c", "d", "e"))
+	})
+	commit(t, w, func(b *Batch) {
+		b.Set("snapshot", []byte("s3"))
+		b.CompactEntries(3)
+	})
+	checkWAL(t, w, 3, []string{"d", "e"}, "snapshot", "s3")
+
+	// a snapshot past the last entry leaves none; appends go on from it.
+	commit(t, w, func(b *Batch) {
+		b.Set("snapshot", []byte("s8"))
+		b.CompactEntries(8)
+		b.AppendEntries(8, entryBytes("i"))
+	})
+	checkWAL(t, w, 8, []string{"i"}, "snapshot", "s8")
+
+	// the checkpoint of the next segment carries the compacted log along.
+	seq := w.seq
+	for i := 9; w.seq == seq; i++ {
+		commit(t, w, func(b *Batch) {
+			b.AppendEntries(i, entryBytes(fmt.Sprint(i)))
+		})
+	}
+	_, entries, _ := w.Entries()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openWAL(t, dir, opts)
+	defer w.Close()
+	first, got, err := w.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 8 || !slices.EqualFunc(got, entries, slices.Equal) {
+		t.Errorf("recovered entries from %d: %q, want from 8: %q", first, got, entries)
+	}
+	if w.checkpointSize == 0 || w.checkpointSize > w.size {
+		t.Errorf("checkpoint of %d bytes in a %d byte segment", w.checkpointSize, w.size)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/pro0o/raft-in-motion/internal/client"
 	"github.com/pro0o/raft-in-motion/internal/harness"
 	"github.com/pro0o/raft-in-motion/internal/logger"
+	"github.com/pro0o/raft-in-motion/internal/raft"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -26,8 +27,32 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	simulateInt, err := strconv.Atoi(simulate)
-	if err != nil || simulateInt != 6 {
-		http.Error(w, "Invalid simulate parameter. Must be between 6", http.StatusBadRequest)
+	if err != nil || simulateInt < 6 || simulateInt > 20 {
+		http.Error(w, "Invalid simulate parameter. Must be between 6 and 20", http.StatusBadRequest)
+		return
+	}
+
+	// slowdown stretches every cluster timing, e.g. slowdown=10 for teaching.
+	cfg := raft.DefaultConfig()
+	if slowdown := r.URL.Query().Get("slowdown"); slowdown != "" {
+		factor, err := strconv.ParseFloat(slowdown, 64)
+		if err != nil || factor <= 0 {
+			http.Error(w, "Invalid slowdown parameter. Must be a positive number", http.StatusBadRequest)
+			return
+		}
+		cfg = cfg.Scale(factor)
+	}
+	// seed runs the scenario under virtual time, repeatable per seed.
+	if seed := r.URL.Query().Get("seed"); seed != "" {
+		seedInt, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil || seedInt == 0 {
+			http.Error(w, "Invalid seed parameter. Must be a non-zero integer", http.StatusBadRequest)
+			return
+		}
+		cfg = harness.VirtualTime(cfg, seedInt)
+	}
+	if err := cfg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -50,14 +75,60 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Logger: memLogger,
 	}
 
+	var run func(cfg raft.Config) error
 	switch simulateInt {
 	case 6:
 		logger.Info("Running Disconnect Leader Test")
-		harness.DisconnectLeaderTest()
+		run = harness.DisconnectLeaderTest
+	case 7:
+		logger.Info("Running Membership Change Test")
+		run = harness.MembershipChangeTest
+	case 8:
+		logger.Info("Running PreVote Test")
+		run = harness.PreVoteTest
+	case 9:
+		logger.Info("Running Leadership Transfer Test")
+		run = harness.LeadershipTransferTest
+	case 10:
+		logger.Info("Running Read Modes Test")
+		run = harness.ReadModesTest
+	case 11:
+		logger.Info("Running Learner Test")
+		run = harness.LearnerTest
+	case 12:
+		logger.Info("Running Crash Restart Test")
+		run = harness.CrashRestartTest
+	case 13:
+		logger.Info("Running Crash Point Test")
+		run = harness.CrashPointTest
+	case 14:
+		logger.Info("Running Large Cluster Test")
+		run = harness.LargeClusterTest
+	case 15:
+		logger.Info("Running Link Fault Test")
+		run = harness.LinkFaultTest
+	case 16:
+		logger.Info("Running Partition Test")
+		run = harness.PartitionTest
+	case 17:
+		logger.Info("Running Pause Test")
+		run = harness.PauseTest
+	case 18:
+		logger.Info("Running Disk Fault Test")
+		run = harness.DiskFaultTest
+	case 19:
+		logger.Info("Running Clock Skew Test")
+		run = harness.ClockSkewTest
+	case 20:
+		logger.Info("Running Nemesis Test")
+		run = harness.NemesisTest
 	default:
 		http.Error(w, "Invalid simulation option", http.StatusBadRequest)
 		return
 	}
+	if err := run(cfg); err != nil {
+		logger.Error("Simulation failed", zap.Int("simulate", simulateInt), zap.Error(err))
+	}
 
 	client.LogClientConnection(true)
 	go client.ReadLoop(c)